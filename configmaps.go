@@ -2,196 +2,134 @@ package main
 
 import (
 	"context"
-	"sync"
+	"encoding/base64"
 
 	"github.com/google/go-cmp/cmp"
-	log "github.com/sirupsen/logrus"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	v1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
 )
 
-type SourceConfigmap struct {
-	configmap        v1.ConfigMap
-	targetNamespaces []string
-}
-
-type ReplicatedConfigmap struct {
-	configmap       v1.ConfigMap
-	sourceNamespace string
-}
-
-// function to list all namespaces and source configmaps and replicate them to the relevant namespaces
-// also scans and deletes any orphaned configmaps.
-// It is optimized by only querying once for the list of source configmaps, replicated configmaps, and namespaces to be replicated to
-func processConfigmaps(clientSet *kubernetes.Clientset, allNamespaces *v1.NamespaceList, wg *sync.WaitGroup) {
-	defer wg.Done()
-	var cm_wg sync.WaitGroup
-	// Get all configmaps
-	allConfigmaps := getAllConfigmaps(clientSet)
-	sourceConfigmaps, replicatedConfigmaps := getSourceAndReplicatedConfigmaps(allConfigmaps, allNamespaces)
-	log.Debugf("There are %d configmaps with the relevant annotations in the cluster", len(sourceConfigmaps))
-
-	// Replicating source configmaps
-	for _, sourceConfigmap := range sourceConfigmaps {
-		// replicate to all relevant namespaces
-		for _, replicateNamespace := range sourceConfigmap.targetNamespaces {
-			cm_wg.Add(1)
-			go replicateConfigmapToNamespace(clientSet, sourceConfigmap.configmap, replicateNamespace, replicatedConfigmaps, &cm_wg)
-		}
-		log.Debugf("Finished replicating all namespaces for configmap %v", sourceConfigmap.configmap.Name)
-	}
+// resourceAdapter implementation for configmaps
+type configmapAdapter struct {
+	clientSet         kubernetes.Interface
+	configmapInformer cache.SharedIndexInformer
+	configmapLister   corelisters.ConfigMapLister
+}
 
-	// Deleting orphaned configmaps
-	for _, replicatedConfigmap := range replicatedConfigmaps {
-		// check if source configmap still exists or regex still valid
-		_, err := getConfigmapInSourceConfigmaps(replicatedConfigmap, sourceConfigmaps)
-		if err != nil {
-			if errors.IsNotFound(err) {
-				cm_wg.Add(1)
-				go deleteConfigmap(clientSet, replicatedConfigmap.configmap, &cm_wg)
-			} else {
-				panic(err.Error())
-			}
-		}
+func newConfigmapAdapter(clientSet kubernetes.Interface, informerFactory informers.SharedInformerFactory) *configmapAdapter {
+	configmapInformer := informerFactory.Core().V1().ConfigMaps()
+	return &configmapAdapter{
+		clientSet:         clientSet,
+		configmapInformer: configmapInformer.Informer(),
+		configmapLister:   configmapInformer.Lister(),
 	}
-	cm_wg.Wait()
+}
+
+func (a *configmapAdapter) kind() string {
+	return "configmap"
+}
+
+func (a *configmapAdapter) informer() cache.SharedIndexInformer {
+	return a.configmapInformer
 }
 
 // Get all configmaps from all namespaces
-func getAllConfigmaps(clientSet *kubernetes.Clientset) *v1.ConfigMapList {
-	allConfigmaps, err := clientSet.CoreV1().ConfigMaps("").List(context.TODO(), metav1.ListOptions{})
+func (a *configmapAdapter) list() ([]kubeObject, error) {
+	configmaps, err := a.configmapLister.List(labels.Everything())
 	if err != nil {
-		panic(err.Error())
+		return nil, err
 	}
-	return allConfigmaps
+	objects := make([]kubeObject, 0, len(configmaps))
+	for _, configmap := range configmaps {
+		objects = append(objects, configmap)
+	}
+	return objects, nil
 }
 
-// Checks if given configmap is a source configmap by checking the annotations
-func isSourceConfigmap(configmap v1.ConfigMap) bool {
-	return metav1.HasAnnotation(configmap.ObjectMeta, REPLICATE_REGEX) || metav1.HasAnnotation(configmap.ObjectMeta, REPLICATE_ALL_NAMESPACES)
+func (a *configmapAdapter) get(namespace string, name string) (kubeObject, error) {
+	return a.configmapLister.ConfigMaps(namespace).Get(name)
 }
 
-// Checks if given configmap is a replicated configmap by checking the annotations
-func isReplicatedConfigmap(configmap v1.ConfigMap) bool {
-	return metav1.HasAnnotation(configmap.ObjectMeta, REPLICATED_ANNOTATION)
+func (a *configmapAdapter) create(object kubeObject) error {
+	configmap := object.(*v1.ConfigMap)
+	_, err := a.clientSet.CoreV1().ConfigMaps(configmap.Namespace).Create(context.TODO(), configmap, metav1.CreateOptions{DryRun: getServerDryRun()})
+	return err
 }
 
-// fuction that takes in all configmaps and returns a list of source Configmaps and a list of replicatedConfigmaps
-func getSourceAndReplicatedConfigmaps(allConfigmaps *v1.ConfigMapList, allNamespaces *v1.NamespaceList) ([]SourceConfigmap, []ReplicatedConfigmap) {
-	// initialize array for SourceConfigmaps and ReplicatedConfigmaps
-	sourceConfigmaps := make([]SourceConfigmap, 0, 10)
-	replicatedConfigmaps := make([]ReplicatedConfigmap, 0, 10)
+func (a *configmapAdapter) update(object kubeObject) error {
+	configmap := object.(*v1.ConfigMap)
+	_, err := a.clientSet.CoreV1().ConfigMaps(configmap.Namespace).Update(context.TODO(), configmap, metav1.UpdateOptions{DryRun: getServerDryRun()})
+	return err
+}
 
-	for _, configmap := range allConfigmaps.Items {
-		if isSourceConfigmap(configmap) {
-			// Filter for all source configmaps
-			targetNamespaces, err := getReplicateNamespaces(allNamespaces, configmap.ObjectMeta)
-			if err != nil {
-				panic(err.Error())
-			}
-			sourceConfigmaps = append(sourceConfigmaps, SourceConfigmap{configmap: configmap, targetNamespaces: targetNamespaces})
-		} else if isReplicatedConfigmap(configmap) {
-			// Filter for all replicated configmaps
-			replicatedConfigmaps = append(replicatedConfigmaps, ReplicatedConfigmap{configmap: configmap, sourceNamespace: configmap.Annotations[REPLICATED_ANNOTATION]})
-		}
+func (a *configmapAdapter) delete(object kubeObject) error {
+	return a.clientSet.CoreV1().ConfigMaps(object.GetNamespace()).Delete(context.TODO(), object.GetName(), metav1.DeleteOptions{DryRun: getServerDryRun()})
+}
+
+func (a *configmapAdapter) patchAnnotations(object kubeObject, annotations map[string]*string) error {
+	patch, err := annotationsMergePatch(annotations)
+	if err != nil {
+		return err
 	}
+	_, err = a.clientSet.CoreV1().ConfigMaps(object.GetNamespace()).Patch(context.TODO(), object.GetName(), types.MergePatchType, patch, metav1.PatchOptions{DryRun: getServerDryRun()})
+	return err
+}
 
-	return sourceConfigmaps, replicatedConfigmaps
+// both the text data and the binary data of configmaps are replicated, empty and missing data are the same.
+// The immutable flag is replicated together with the data
+func (a *configmapAdapter) equalData(originalObject kubeObject, replicatedObject kubeObject) bool {
+	originalConfigmap, replicatedConfigmap := originalObject.(*v1.ConfigMap), replicatedObject.(*v1.ConfigMap)
+	return cmp.Equal(originalConfigmap.Data, replicatedConfigmap.Data, cmpopts.EquateEmpty()) &&
+		cmp.Equal(originalConfigmap.BinaryData, replicatedConfigmap.BinaryData, cmpopts.EquateEmpty()) &&
+		isImmutable(originalConfigmap.Immutable) == isImmutable(replicatedConfigmap.Immutable)
 }
 
-// Get configmap in array of SourceConfigmaps, error if not found or the replicatedConfigmap Namespace is no longer valid to be replicated into (i.e. regex changed in the source configmap).
-// Used to search for the source configmap given a replicated one.
-func getConfigmapInSourceConfigmaps(replicatedConfigmap ReplicatedConfigmap, sourceConfigmaps []SourceConfigmap) (v1.ConfigMap, error) {
-	for _, sourceConfigmap := range sourceConfigmaps {
-		if sourceConfigmap.configmap.Name == replicatedConfigmap.configmap.Name &&
-			replicatedConfigmap.sourceNamespace == sourceConfigmap.configmap.Namespace &&
-			arrayContains(sourceConfigmap.targetNamespaces, replicatedConfigmap.configmap.Namespace) {
-			return sourceConfigmap.configmap, nil
-		}
-	}
-	return v1.ConfigMap{}, errors.NewNotFound(schema.GroupResource{}, "")
-}
-
-// Get configmap in array of replicatedConfigmaps, error if not found.
-// Used to search for the replicated configmap given a sourceConfigmap.
-func getConfigmapInReplicatedConfigmaps(configmap v1.ConfigMap, replicatedConfigmaps []ReplicatedConfigmap, namespace string) (v1.ConfigMap, error) {
-	for _, replicatedConfigmap := range replicatedConfigmaps {
-		if replicatedConfigmap.configmap.Name == configmap.Name &&
-			replicatedConfigmap.sourceNamespace == configmap.Namespace &&
-			replicatedConfigmap.configmap.Namespace == namespace {
-			return replicatedConfigmap.configmap, nil
-		}
-	}
-	return v1.ConfigMap{}, errors.NewNotFound(schema.GroupResource{}, "")
+// immutable configmaps can only be deleted
+func (a *configmapAdapter) requiresRecreate(replicatedObject kubeObject, copiedObject kubeObject) bool {
+	return isImmutable(replicatedObject.(*v1.ConfigMap).Immutable) && !a.equalData(replicatedObject, copiedObject)
 }
 
-// Replicate source configmap to target namespace
-// Creates the replicate configmap if it does not exist, and update it if it exists and is not the same
-func replicateConfigmapToNamespace(clientSet *kubernetes.Clientset, configmap v1.ConfigMap, namespace string, replicatedConfigmaps []ReplicatedConfigmap, wg *sync.WaitGroup) {
-	defer wg.Done()
-	// do nothing if the target namespace is the same as the source configmap namespace
-	if namespace == configmap.Namespace {
-		return
+func (a *configmapAdapter) recreate(replicatedObject kubeObject, copiedObject kubeObject) (string, error) {
+	return recreateObject(a, replicatedObject, copiedObject)
+}
+
+func (a *configmapAdapter) filterData(object kubeObject, keyFilter func(key string) bool) {
+	filterMap(object.(*v1.ConfigMap).Data, keyFilter)
+	filterMap(object.(*v1.ConfigMap).BinaryData, keyFilter)
+}
+
+func (a *configmapAdapter) diffData(object kubeObject) (map[string]string, bool) {
+	configmap := object.(*v1.ConfigMap)
+	data := make(map[string]string)
+	for k, v := range configmap.Data {
+		data["data."+k] = v
 	}
-	// Remove annotation
-	copied_configmap := configmap.DeepCopy()
-	delete(copied_configmap.Annotations, REPLICATE_REGEX)
-	delete(copied_configmap.Annotations, REPLICATE_ALL_NAMESPACES)
-	// add replicated-from annotation
-	copied_configmap.Annotations[REPLICATED_ANNOTATION] = configmap.Namespace
-	copied_configmap.Namespace = namespace
-	copied_configmap.ResourceVersion = ""
-
-	existing_configmap, err := getConfigmapInReplicatedConfigmaps(configmap, replicatedConfigmaps, namespace)
-	if err != nil {
-		if errors.IsNotFound(err) {
-			// Create configmap if it does not exist
-			log.Infof("Replicating [resource=configmap][ns=%v][name=%v] to %v namespace...", configmap.Namespace, configmap.Name, namespace)
-			_, err := clientSet.CoreV1().ConfigMaps(namespace).Create(context.TODO(), copied_configmap, metav1.CreateOptions{})
-			if err != nil {
-				panic(err.Error())
-			}
-		} else {
-			panic(err.Error())
-		}
-	} else {
-		// Check if configmap value is the same if it exists
-		// and updates the configmap if it is changed
-		if !checkConfigmapEquality(*copied_configmap, existing_configmap) {
-			// updates configmap
-			updated_configmap := existing_configmap.DeepCopy()
-			updated_configmap.Annotations = copied_configmap.Annotations
-			updated_configmap.Data = copied_configmap.Data
-			updated_configmap.Labels = copied_configmap.Labels
-			log.Infof("Updating [resource=configmap][ns=%v][name=%v] to %v namespace...", configmap.Namespace, configmap.Name, namespace)
-			_, err := clientSet.CoreV1().ConfigMaps(namespace).Update(context.TODO(), updated_configmap, metav1.UpdateOptions{})
-			if err != nil {
-				panic(err.Error())
-			}
-		}
+	for k, v := range configmap.BinaryData {
+		data["binaryData."+k] = base64.StdEncoding.EncodeToString(v)
 	}
+	return data, false
 }
 
-// checks 2 configmaps if they are the same
-// this function checks the values, labels, and annotations
-func checkConfigmapEquality(originalConfigmap v1.ConfigMap, replicatedConfigmap v1.ConfigMap) bool {
-	originalAnnotation := stripAllReplicatorAnnotations(originalConfigmap.Annotations)
-	replicatedAnnotation := stripAllReplicatorAnnotations(replicatedConfigmap.Annotations)
+func (a *configmapAdapter) copyData(dst kubeObject, src kubeObject) {
+	dst.(*v1.ConfigMap).Data = src.(*v1.ConfigMap).Data
+	dst.(*v1.ConfigMap).BinaryData = src.(*v1.ConfigMap).BinaryData
+	dst.(*v1.ConfigMap).Immutable = src.(*v1.ConfigMap).Immutable
+}
 
-	return cmp.Equal(originalConfigmap.Data, replicatedConfigmap.Data) &&
-		cmp.Equal(originalAnnotation, replicatedAnnotation) &&
-		cmp.Equal(originalConfigmap.Labels, replicatedConfigmap.Labels)
+func (a *configmapAdapter) equalPullData(sourceObject kubeObject, targetObject kubeObject) bool {
+	sourceConfigmap, targetConfigmap := sourceObject.(*v1.ConfigMap), targetObject.(*v1.ConfigMap)
+	return cmp.Equal(sourceConfigmap.Data, targetConfigmap.Data, cmpopts.EquateEmpty()) &&
+		cmp.Equal(sourceConfigmap.BinaryData, targetConfigmap.BinaryData, cmpopts.EquateEmpty())
 }
 
-// deletes configmap
-func deleteConfigmap(clientSet *kubernetes.Clientset, configmap v1.ConfigMap, wg *sync.WaitGroup) {
-	defer wg.Done()
-	log.Infof("Deleting configmap %v in namespace %v...", configmap.Name, configmap.Namespace)
-	err := clientSet.CoreV1().ConfigMaps(configmap.Namespace).Delete(context.TODO(), configmap.Name, metav1.DeleteOptions{})
-	if err != nil {
-		panic(err.Error())
-	}
+func (a *configmapAdapter) copyPullData(dst kubeObject, src kubeObject) {
+	dst.(*v1.ConfigMap).Data = src.(*v1.ConfigMap).Data
+	dst.(*v1.ConfigMap).BinaryData = src.(*v1.ConfigMap).BinaryData
 }
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestConfigmapAdapterBinaryData(t *testing.T) {
+	source := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app", Annotations: map[string]string{REPLICATE_REGEX: "ns-1"}},
+		Data:       map[string]string{"key": "value"},
+		BinaryData: map[string][]byte{"keystore.jks": {0xfe, 0xed, 0xfe, 0xed}},
+	}
+	replica := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns-1", Name: "app", Annotations: map[string]string{REPLICATED_ANNOTATION: "default/app", REPLICATED_FROM_UID_ANNOTATION: ""}},
+		Data:       map[string]string{"key": "value"},
+		BinaryData: map[string][]byte{"keystore.jks": {0x00}},
+	}
+	clientSet := fake.NewSimpleClientset(source, replica)
+	adapter := newConfigmapAdapter(clientSet, informers.NewSharedInformerFactory(clientSet, 0))
+	allNamespaces := newTestNamespaces("default", "ns-1")
+
+	// the replica is updated when only the binary data changed
+	if result := processResources(adapter, record.NewFakeRecorder(10), allNamespaces, []kubeObject{source, replica}); len(result.errors) > 0 {
+		t.Fatalf("unexpected replication errors: %v", result.errors)
+	}
+	updatedReplica, err := clientSet.CoreV1().ConfigMaps("ns-1").Get(context.TODO(), "app", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected replica to exist: %v", err)
+	}
+	if !cmp.Equal(updatedReplica.BinaryData, source.BinaryData) {
+		t.Errorf("unexpected replica binary data: %v", cmp.Diff(source.BinaryData, updatedReplica.BinaryData))
+	}
+
+	// and left untouched once it is the same
+	clientSet.ClearActions()
+	if result := processResources(adapter, record.NewFakeRecorder(10), allNamespaces, []kubeObject{source, updatedReplica}); len(result.errors) > 0 {
+		t.Fatalf("unexpected replication errors: %v", result.errors)
+	}
+	if actions := withoutStatusPatches(clientSet.Actions()); len(actions) > 0 {
+		t.Errorf("expected no api calls for an unchanged replica, got %v", actions)
+	}
+}
+
+func TestConfigmapAdapterRecreate(t *testing.T) {
+	immutable := true
+	tests := []struct {
+		name           string
+		source         *v1.ConfigMap
+		replica        *v1.ConfigMap
+		expectRecreate bool
+	}{
+		{
+			name:           "immutable replica with changed data",
+			source:         &v1.ConfigMap{Immutable: &immutable, Data: map[string]string{"key": "new"}},
+			replica:        &v1.ConfigMap{Immutable: &immutable, Data: map[string]string{"key": "old"}},
+			expectRecreate: true,
+		},
+		{
+			name:    "source made immutable",
+			source:  &v1.ConfigMap{Immutable: &immutable, Data: map[string]string{"key": "value"}},
+			replica: &v1.ConfigMap{Data: map[string]string{"key": "value"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func(recreateReplicas bool) { configRecreateReplicas = recreateReplicas }(configRecreateReplicas)
+			configRecreateReplicas = true
+
+			test.source.ObjectMeta = metav1.ObjectMeta{Namespace: "default", Name: "app", Annotations: map[string]string{REPLICATE_REGEX: "ns-1"}}
+			test.replica.ObjectMeta = metav1.ObjectMeta{Namespace: "ns-1", Name: "app", Annotations: map[string]string{REPLICATED_ANNOTATION: "default/app"}}
+			clientSet := fake.NewSimpleClientset(test.source, test.replica)
+			adapter := newConfigmapAdapter(clientSet, informers.NewSharedInformerFactory(clientSet, 0))
+
+			if errs := processResources(adapter, record.NewFakeRecorder(10), newTestNamespaces("default", "ns-1"), []kubeObject{test.source, test.replica}).errors; len(errs) > 0 {
+				t.Fatalf("unexpected replication errors: %v", errs)
+			}
+			verbs := make([]string, 0)
+			for _, action := range clientSet.Actions() {
+				verbs = append(verbs, action.GetVerb())
+			}
+			recreated := arrayContains(verbs, "delete") && arrayContains(verbs, "create")
+			if recreated != test.expectRecreate {
+				t.Errorf("expected recreate to be %v, got api calls %v", test.expectRecreate, verbs)
+			}
+			if !test.expectRecreate && !arrayContains(verbs, "update") {
+				t.Errorf("expected the replica to be updated, got api calls %v", verbs)
+			}
+
+			replica, err := clientSet.CoreV1().ConfigMaps("ns-1").Get(context.TODO(), "app", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("expected replica to exist: %v", err)
+			}
+			if !adapter.equalData(test.source, replica) {
+				t.Errorf("expected replica to have the immutable flag and data of the source, got %v", replica)
+			}
+		})
+	}
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// kind of the special workqueue item that triggers a full resync, other items use the kind of their resourceAdapter
+const KIND_RESYNC string = "resync"
+
+// Item in the workqueue, identifies a source object by its kind, namespace and name.
+// Replicated objects are queued under the key of their source object.
+type queueKey struct {
+	kind      string
+	namespace string
+	name      string
+}
+
+// special key that triggers a full reconciliation of every object in the informer caches
+var resyncKey = queueKey{kind: KIND_RESYNC}
+
+// name of the informer index of the replicated and pull target objects by the <namespace>/<name> of their source object
+const SOURCE_INDEX string = "source"
+
+// indexes replicated objects by their source object, and pull target objects by the source object they pull from
+func sourceIndexFunc(obj interface{}) ([]string, error) {
+	object, ok := obj.(metav1.Object)
+	if !ok {
+		return nil, nil
+	}
+	if isReplicatedObject(object) {
+		sourceNamespace, sourceName := getReplicatedSource(object)
+		return []string{sourceNamespace + "/" + sourceName}, nil
+	}
+	if sourceNamespace, sourceName, ok := getPullSource(object); ok {
+		return []string{sourceNamespace + "/" + sourceName}, nil
+	}
+	return nil, nil
+}
+
+// drops the managed fields of the objects before they are stored in the informer caches, as they are never used
+// and make up a large part of the memory of the cached objects
+func stripManagedFields(obj interface{}) (interface{}, error) {
+	if object, ok := obj.(metav1.Object); ok {
+		object.SetManagedFields(nil)
+	}
+	return obj, nil
+}
+
+// Controller watches namespaces and every replicated kind of resource with shared informers and reconciles
+// the source objects that are affected by every change through a rate-limited workqueue
+type Controller struct {
+	informerFactory        informers.SharedInformerFactory
+	dynamicInformerFactory dynamicinformer.DynamicSharedInformerFactory
+	namespaceLister        corelisters.NamespaceLister
+	adapters               []resourceAdapter
+	eventBroadcaster       record.EventBroadcaster
+	recorder               record.EventRecorder
+	informersSynced        []cache.InformerSynced
+	queue                  workqueue.RateLimitingInterface
+	resyncPeriod           time.Duration
+	health                 *healthStatus
+}
+
+// Creates a controller replicating secrets and configmaps, and every additional resource in resources through the dynamic client
+func newController(clientSet kubernetes.Interface, dynamicClient dynamic.Interface, resources []schema.GroupVersionResource, resyncPeriod time.Duration) *Controller {
+	informerFactory := informers.NewSharedInformerFactory(clientSet, 0)
+	dynamicInformerFactory := dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, 0)
+	namespaceInformer := informerFactory.Core().V1().Namespaces()
+	eventBroadcaster, recorder := newEventRecorder(clientSet)
+
+	c := &Controller{
+		informerFactory:        informerFactory,
+		dynamicInformerFactory: dynamicInformerFactory,
+		namespaceLister:        namespaceInformer.Lister(),
+		adapters: []resourceAdapter{
+			newSecretAdapter(clientSet, informerFactory),
+			newConfigmapAdapter(clientSet, informerFactory),
+		},
+		eventBroadcaster: eventBroadcaster,
+		recorder:         recorder,
+		informersSynced:  []cache.InformerSynced{namespaceInformer.Informer().HasSynced},
+		queue:            workqueue.NewNamedRateLimitingQueue(newRateLimiter(), "resource-replicator"),
+		resyncPeriod:     resyncPeriod,
+		health:           newHealthStatus(time.Now()),
+	}
+	for _, gvr := range resources {
+		c.adapters = append(c.adapters, newUnstructuredAdapter(dynamicClient, dynamicInformerFactory, gvr))
+	}
+	for i, adapter := range c.adapters {
+		c.adapters[i] = newInstrumentedAdapter(adapter)
+		if configDryRun {
+			c.adapters[i] = newDryRunAdapter(c.adapters[i])
+		}
+	}
+
+	namespaceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		// a new namespace, or a namespace with changed labels, may match the replication annotations of any source
+		AddFunc: func(obj interface{}) { c.enqueueAllSources() },
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			if !labels.Equals(oldObj.(*v1.Namespace).Labels, newObj.(*v1.Namespace).Labels) {
+				c.enqueueAllSources()
+			}
+		},
+	})
+	for _, adapter := range c.adapters {
+		if err := adapter.informer().SetTransform(stripManagedFields); err != nil {
+			log.WithField(LOG_FIELD_KIND, adapter.kind()).WithError(err).Error("Error setting the informer transform")
+		}
+		if err := adapter.informer().AddIndexers(cache.Indexers{SOURCE_INDEX: sourceIndexFunc}); err != nil {
+			log.WithField(LOG_FIELD_KIND, adapter.kind()).WithError(err).Error("Error adding the informer index")
+		}
+		adapter.informer().AddEventHandler(c.eventHandler(adapter.kind()))
+		c.informersSynced = append(c.informersSynced, adapter.informer().HasSynced)
+	}
+
+	return c
+}
+
+// Failed items are retried with an exponential backoff per item, capped by an overall rate limit
+func newRateLimiter() workqueue.RateLimiter {
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(time.Second, 5*time.Minute),
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(10), 100)},
+	)
+}
+
+// returns the resourceAdapter of the given kind, nil if there is none
+func (c *Controller) adapter(kind string) resourceAdapter {
+	for _, adapter := range c.adapters {
+		if adapter.kind() == kind {
+			return adapter
+		}
+	}
+	return nil
+}
+
+// returns event handlers that queue the source object affected by a change to an object of the given kind
+func (c *Controller) eventHandler(kind string) cache.ResourceEventHandlerFuncs {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) { c.enqueueObject(kind, obj) },
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			// the replication status written to a source object does not affect its replication
+			if isStatusOnlyUpdate(oldObj, newObj) {
+				return
+			}
+			// the old object is queued as well so that removing the replication annotations prunes the replicas
+			c.enqueueObject(kind, oldObj)
+			c.enqueueObject(kind, newObj)
+		},
+		DeleteFunc: func(obj interface{}) { c.enqueueObject(kind, obj) },
+	}
+}
+
+// queues the key of the source object for a source, a replicated or a pull target object, other objects are ignored
+func (c *Controller) enqueueObject(kind string, obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	object, ok := obj.(metav1.Object)
+	if !ok {
+		log.WithField(LOG_FIELD_KIND, kind).Warnf("Ignoring unexpected object of type %T in event handler", obj)
+		return
+	}
+	if isSourceObject(object) || isPullSourceObject(object) {
+		c.queue.Add(queueKey{kind: kind, namespace: object.GetNamespace(), name: object.GetName()})
+	} else if isReplicatedObject(object) {
+		sourceNamespace, sourceName := getReplicatedSource(object)
+		c.queue.Add(queueKey{kind: kind, namespace: sourceNamespace, name: sourceName})
+	}
+	if sourceNamespace, sourceName, ok := getPullSource(object); ok {
+		c.queue.Add(queueKey{kind: kind, namespace: sourceNamespace, name: sourceName})
+	}
+}
+
+// queues every source object in the informer caches
+func (c *Controller) enqueueAllSources() {
+	for _, adapter := range c.adapters {
+		objects, err := adapter.list()
+		if err != nil {
+			log.WithField(LOG_FIELD_KIND, adapter.kind()).WithError(err).Error("Error listing objects")
+			continue
+		}
+		for _, object := range objects {
+			if isSourceObject(object) {
+				c.queue.Add(queueKey{kind: adapter.kind(), namespace: object.GetNamespace(), name: object.GetName()})
+			}
+		}
+	}
+}
+
+// Starts the informers and blocks until stopCh is closed.
+// A single worker processes the workqueue so that a full resync never runs concurrently with the reconciliation of a single source.
+func (c *Controller) run(stopCh <-chan struct{}) {
+	defer c.queue.ShutDown()
+	defer c.eventBroadcaster.Shutdown()
+
+	c.informerFactory.Start(stopCh)
+	c.dynamicInformerFactory.Start(stopCh)
+	log.Info("Waiting for informer caches to sync...")
+	if !cache.WaitForCacheSync(stopCh, c.informersSynced...) {
+		log.Error("Timed out waiting for informer caches to sync")
+		return
+	}
+	log.Info("Informer caches synced")
+	if !configSubstringMatch {
+		c.warnSubstringMatchChanges()
+	}
+
+	go wait.Until(c.runWorker, time.Second, stopCh)
+	// periodically reconcile everything, this also runs the initial full reconciliation
+	go wait.Until(func() { c.queue.Add(resyncKey) }, c.resyncPeriod, stopCh)
+
+	<-stopCh
+	log.Info("Shutting down controller")
+}
+
+// Starts the informers and runs a single full reconciliation once the informer caches are synced.
+// Returns the result of the reconciliation, or an error if it could not run
+func (c *Controller) runOnce(stopCh <-chan struct{}) (*replicationResult, error) {
+	defer c.eventBroadcaster.Shutdown()
+
+	c.informerFactory.Start(stopCh)
+	c.dynamicInformerFactory.Start(stopCh)
+	log.Info("Waiting for informer caches to sync...")
+	if !cache.WaitForCacheSync(stopCh, c.informersSynced...) {
+		return nil, fmt.Errorf("timed out waiting for informer caches to sync")
+	}
+	if !configSubstringMatch {
+		c.warnSubstringMatchChanges()
+	}
+	allNamespaces, err := c.getAllNamespaces()
+	if err != nil {
+		return nil, err
+	}
+	result, err := loop(c.adapters, c.recorder, allNamespaces)
+	if err != nil {
+		return nil, err
+	}
+	// a single reconciliation cannot wait for the grace period, the orphans are marked and left for a later run
+	if result.pendingOrphans > 0 {
+		log.Warnf("%d orphaned replicas were not handled as they are within the orphan grace period of %v, they are handled by a later run once the grace period has passed", result.pendingOrphans, configOrphanGracePeriod)
+	}
+	return result, nil
+}
+
+// Logs a warning for every source object that is no longer replicated to some namespaces with anchored pattern matching,
+// compared to the substring matching of previous versions
+func (c *Controller) warnSubstringMatchChanges() {
+	allNamespaces, err := c.getAllNamespaces()
+	if err != nil {
+		log.WithError(err).Error("Error listing namespaces")
+		return
+	}
+	for _, adapter := range c.adapters {
+		objects, err := adapter.list()
+		if err != nil {
+			log.WithField(LOG_FIELD_KIND, adapter.kind()).WithError(err).Error("Error listing objects")
+			continue
+		}
+		for _, object := range objects {
+			if !isSourceObject(object) || useSubstringMatch(object) {
+				continue
+			}
+			removedNamespaces, err := substringMatchChanges(allNamespaces, object)
+			if err != nil {
+				continue
+			}
+			if len(removedNamespaces) > 0 {
+				sourceLog(adapter, object).Warnf("No longer replicated to namespaces %v, as namespace patterns now have to match the full namespace name. Set the %v annotation to \"true\" to keep the previous substring matching",
+					strings.Join(removedNamespaces, ","), SUBSTRING_MATCH)
+			}
+		}
+	}
+}
+
+func (c *Controller) runWorker() {
+	for c.processNextItem() {
+	}
+}
+
+// processes a single item from the workqueue, returns false when the queue is shut down
+func (c *Controller) processNextItem() bool {
+	item, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(item)
+
+	key := item.(queueKey)
+	if err := c.sync(key); err != nil {
+		log.WithFields(log.Fields{
+			LOG_FIELD_KIND:             key.kind,
+			LOG_FIELD_SOURCE_NAMESPACE: key.namespace,
+			LOG_FIELD_SOURCE_NAME:      key.name,
+		}).WithError(err).Error("Error syncing, requeuing")
+		c.queue.AddRateLimited(key)
+		return true
+	}
+	c.queue.Forget(key)
+	return true
+}
+
+func (c *Controller) sync(key queueKey) error {
+	allNamespaces, err := c.getAllNamespaces()
+	if err != nil {
+		return err
+	}
+	if key.kind == KIND_RESYNC {
+		log.Info("Checking...")
+		start := time.Now()
+		result, err := loop(c.adapters, c.recorder, allNamespaces)
+		if err != nil {
+			return err
+		}
+		c.health.loopFinished(time.Now())
+		// retry the failed sources on their own with backoff, instead of retrying the whole resync
+		failedSources := make(map[queueKey]bool)
+		for _, replicationError := range result.errors {
+			failedSources[queueKey{kind: replicationError.kind, namespace: replicationError.sourceNamespace, name: replicationError.sourceName}] = true
+		}
+		for key := range failedSources {
+			c.queue.AddRateLimited(key)
+		}
+		log.WithField(LOG_FIELD_DURATION, time.Since(start).Seconds()).Debugf("End of loop: %v", result)
+		return nil
+	}
+	adapter := c.adapter(key.kind)
+	if adapter == nil {
+		log.WithField(LOG_FIELD_KIND, key.kind).Warn("Ignoring workqueue item of unknown kind")
+		return nil
+	}
+	return c.syncSource(adapter, key, allNamespaces)
+}
+
+// reconciles a single source object together with all of its replicated objects and the objects replicating from it
+func (c *Controller) syncSource(adapter resourceAdapter, key queueKey, allNamespaces *v1.NamespaceList) error {
+	objects := make([]kubeObject, 0, 10)
+	source, err := adapter.get(key.namespace, key.name)
+	if err == nil {
+		objects = append(objects, source)
+	} else if !errors.IsNotFound(err) {
+		return err
+	}
+
+	// the replicated and pull target objects of the source object
+	items, err := adapter.informer().GetIndexer().ByIndex(SOURCE_INDEX, key.namespace+"/"+key.name)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if object, ok := item.(kubeObject); ok {
+			objects = append(objects, object)
+		}
+	}
+
+	result := processResources(adapter, c.recorder, allNamespaces, objects)
+	if len(result.errors) > 0 {
+		// every error has already been logged with its target namespace by result.add
+		return fmt.Errorf("%d replication errors", len(result.errors))
+	}
+	return nil
+}
+
+// Get all namespaces from the informer cache
+func (c *Controller) getAllNamespaces() (*v1.NamespaceList, error) {
+	namespaces, err := c.namespaceLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	allNamespaces := &v1.NamespaceList{}
+	for _, namespace := range namespaces {
+		allNamespaces.Items = append(allNamespaces.Items, *namespace)
+	}
+	return allNamespaces, nil
+}
+
+// main loop function that uses goroutines to process every kind of resource in the informer caches
+// includes waitGroup to block code execution until the loop function full completes.
+// This is to ensure the loop is fully executed before the next item in the workqueue is processed.
+// Returns the result of every kind of resource together
+func loop(adapters []resourceAdapter, recorder record.EventRecorder, allNamespaces *v1.NamespaceList) (*replicationResult, error) {
+	start := time.Now()
+	allObjects := make([][]kubeObject, len(adapters))
+	for i, adapter := range adapters {
+		objects, err := adapter.list()
+		if err != nil {
+			return nil, err
+		}
+		allObjects[i] = objects
+	}
+
+	var wg sync.WaitGroup
+	allResults := make([]*replicationResult, len(adapters))
+	wg.Add(len(adapters))
+	for i, adapter := range adapters {
+		go func(i int, adapter resourceAdapter) {
+			defer wg.Done()
+			allResults[i] = processResources(adapter, recorder, allNamespaces, allObjects[i])
+		}(i, adapter)
+	}
+	wg.Wait()
+
+	result := &replicationResult{}
+	for i, kindResult := range allResults {
+		sourceObjectsGauge.WithLabelValues(adapters[i].kind()).Set(float64(kindResult.sources))
+		replicatedObjectsGauge.WithLabelValues(adapters[i].kind()).Set(float64(kindResult.replicas))
+		result.merge(kindResult)
+	}
+	loopDurationSeconds.Observe(time.Since(start).Seconds())
+	// a dry-run reconciliation does not bring the replicas up to date
+	if len(result.errors) == 0 && !configDryRun {
+		lastSuccessfulReconcileTimestamp.SetToCurrentTime()
+	}
+	return result, nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func newTestController() *Controller {
+	return newController(fake.NewSimpleClientset(), dynamicfake.NewSimpleDynamicClient(runtime.NewScheme()), nil, time.Minute)
+}
+
+func TestEnqueueObject(t *testing.T) {
+	tests := []struct {
+		name     string
+		object   interface{}
+		expected []queueKey
+	}{
+		{
+			name:     "source object",
+			object:   &v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app", Annotations: map[string]string{REPLICATE_REGEX: "ns-1"}}},
+			expected: []queueKey{{kind: "secret", namespace: "default", name: "app"}},
+		},
+		{
+			name:     "replicated object is queued under its source",
+			object:   &v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "ns-1", Name: "app", Annotations: map[string]string{REPLICATED_ANNOTATION: "default/source"}}},
+			expected: []queueKey{{kind: "secret", namespace: "default", name: "source"}},
+		},
+		{
+			name:     "replicated object from a previous version is queued under the source with its name",
+			object:   &v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "ns-1", Name: "app", Annotations: map[string]string{REPLICATED_ANNOTATION: "default"}}},
+			expected: []queueKey{{kind: "secret", namespace: "default", name: "app"}},
+		},
+		{
+			name: "deleted source object",
+			object: cache.DeletedFinalStateUnknown{
+				Key: "default/app",
+				Obj: &v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app", Annotations: map[string]string{REPLICATE_ALL_NAMESPACES: "true"}}},
+			},
+			expected: []queueKey{{kind: "secret", namespace: "default", name: "app"}},
+		},
+		{
+			name:     "pull source object",
+			object:   &v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app", Annotations: map[string]string{REPLICATION_ALLOWED_NAMESPACES: "ns-1"}}},
+			expected: []queueKey{{kind: "secret", namespace: "default", name: "app"}},
+		},
+		{
+			name:     "pull target object is queued under its source",
+			object:   &v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "ns-1", Name: "creds", Annotations: map[string]string{REPLICATE_FROM: "default/app"}}},
+			expected: []queueKey{{kind: "secret", namespace: "default", name: "app"}},
+		},
+		{
+			name:     "unrelated object",
+			object:   &v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app"}},
+			expected: []queueKey{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newTestController()
+			defer c.queue.ShutDown()
+			c.enqueueObject("secret", test.object)
+
+			if c.queue.Len() != len(test.expected) {
+				t.Fatalf("expected %d queued items, got %d", len(test.expected), c.queue.Len())
+			}
+			for _, expected := range test.expected {
+				item, _ := c.queue.Get()
+				if item.(queueKey) != expected {
+					t.Errorf("expected %v to be queued, got %v", expected, item)
+				}
+				c.queue.Done(item)
+			}
+		})
+	}
+}
+
+func TestSourceIndexFunc(t *testing.T) {
+	tests := []struct {
+		name     string
+		object   interface{}
+		expected []string
+	}{
+		{
+			name:     "replicated object",
+			object:   &v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "ns-1", Name: "app", Annotations: map[string]string{REPLICATED_ANNOTATION: "default/source"}}},
+			expected: []string{"default/source"},
+		},
+		{
+			name:     "pull target object",
+			object:   &v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "ns-1", Name: "creds", Annotations: map[string]string{REPLICATE_FROM: "default/app"}}},
+			expected: []string{"default/app"},
+		},
+		{
+			name:   "source object",
+			object: &v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app", Annotations: map[string]string{REPLICATE_REGEX: "ns-1"}}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			keys, err := sourceIndexFunc(test.object)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !cmp.Equal(keys, test.expected, cmpopts.EquateEmpty()) {
+				t.Errorf("unexpected index keys: %v", cmp.Diff(test.expected, keys))
+			}
+		})
+	}
+}
+
+func TestRunOnce(t *testing.T) {
+	clientSet := fake.NewSimpleClientset(
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns-1"}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns-2"}},
+		&v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app", Annotations: map[string]string{REPLICATE_REGEX: "ns-[0-9]"}}, Data: map[string][]byte{"key": []byte("new")}},
+		&v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "ns-1", Name: "app", Annotations: map[string]string{REPLICATED_ANNOTATION: "default/app"}}, Data: map[string][]byte{"key": []byte("old")}},
+		&v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "ns-1", Name: "orphan", Annotations: map[string]string{REPLICATED_ANNOTATION: "default/orphan"}}},
+	)
+	c := newController(clientSet, dynamicfake.NewSimpleDynamicClient(runtime.NewScheme()), nil, time.Minute)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	result, err := c.runOnce(stopCh)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.errors) > 0 {
+		t.Fatalf("unexpected replication errors: %v", result.errors)
+	}
+	if summary := result.String(); summary != "sources=1 targets=2 created=1 updated=1 deleted=1 pending_orphans=0 errors=0" {
+		t.Errorf("unexpected summary %q", summary)
+	}
+}
+
+func TestRunOnceOrphanGracePeriod(t *testing.T) {
+	defer func(gracePeriod time.Duration) { configOrphanGracePeriod = gracePeriod }(configOrphanGracePeriod)
+	configOrphanGracePeriod = 30 * time.Second
+
+	clientSet := fake.NewSimpleClientset(
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns-1"}},
+		&v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "ns-1", Name: "orphan", Annotations: map[string]string{REPLICATED_ANNOTATION: "default/orphan"}}},
+	)
+	c := newController(clientSet, dynamicfake.NewSimpleDynamicClient(runtime.NewScheme()), nil, time.Minute)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	// the orphan cannot be deleted by a single reconciliation, it is reported as pending instead
+	result, err := c.runOnce(stopCh)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if summary := result.String(); summary != "sources=0 targets=0 created=0 updated=0 deleted=0 pending_orphans=1 errors=0" {
+		t.Errorf("unexpected summary %q", summary)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Returns the DryRun option of the api requests, the requests are only validated by the api server with CONFIG_SERVER_DRY_RUN
+func getServerDryRun() []string {
+	if configDryRun && configServerDryRun {
+		return []string{metav1.DryRunAll}
+	}
+	return nil
+}
+
+// resourceAdapter that logs the creates, updates and deletes of the wrapped adapter instead of doing them.
+// With CONFIG_SERVER_DRY_RUN the requests are still sent to validate them, with the DryRun option of the api server
+type dryRunAdapter struct {
+	resourceAdapter
+}
+
+func newDryRunAdapter(adapter resourceAdapter) *dryRunAdapter {
+	return &dryRunAdapter{resourceAdapter: adapter}
+}
+
+func (a *dryRunAdapter) create(object kubeObject) error {
+	a.logCreate(object)
+	if !configServerDryRun {
+		return nil
+	}
+	return a.resourceAdapter.create(object)
+}
+
+func (a *dryRunAdapter) logCreate(object kubeObject) {
+	targetLog(a, object).WithField(LOG_FIELD_CHANGES, a.describeChanges(nil, object)).Info("[dry-run] Would create")
+}
+
+// the dry-run delete leaves the replica in place, so the create is only logged as the api server would reject it as already existing
+func (a *dryRunAdapter) recreate(replicatedObject kubeObject, copiedObject kubeObject) (string, error) {
+	if err := deleteObject(a, replicatedObject); err != nil {
+		return ACTION_NONE, err
+	}
+	a.logCreate(copiedObject)
+	return ACTION_RECREATE, nil
+}
+
+func (a *dryRunAdapter) update(object kubeObject) error {
+	// the current object is read from the informer cache to describe what changes
+	changes := "current object not found in the informer cache"
+	if currentObject, err := a.get(object.GetNamespace(), object.GetName()); err == nil {
+		changes = a.describeChanges(currentObject, object)
+	}
+	targetLog(a, object).WithField(LOG_FIELD_CHANGES, changes).Info("[dry-run] Would update")
+	if !configServerDryRun {
+		return nil
+	}
+	return a.resourceAdapter.update(object)
+}
+
+func (a *dryRunAdapter) delete(object kubeObject) error {
+	targetLog(a, object).Info("[dry-run] Would delete")
+	if !configServerDryRun {
+		return nil
+	}
+	return a.resourceAdapter.delete(object)
+}
+
+func (a *dryRunAdapter) patchAnnotations(object kubeObject, annotations map[string]*string) error {
+	changes := make([]string, 0, len(annotations))
+	for annotation, value := range annotations {
+		if value == nil {
+			changes = append(changes, "-annotation "+annotation)
+		} else {
+			changes = append(changes, fmt.Sprintf("annotation %v=%q", annotation, *value))
+		}
+	}
+	sort.Strings(changes)
+	// annotations are patched on source objects and on orphaned replicas
+	entry := targetLog(a, object)
+	if isSourceObject(object) {
+		entry = sourceLog(a, object)
+	}
+	entry.WithField(LOG_FIELD_CHANGES, strings.Join(changes, ", ")).Info("[dry-run] Would patch")
+	if !configServerDryRun {
+		return nil
+	}
+	return a.resourceAdapter.patchAnnotations(object, annotations)
+}
+
+// describes the changed keys of the data, labels and annotations from the current object to the object, the current object is nil for creates.
+// Values of the data and annotations are masked if they are sensitive (i.e. secrets), as annotations like the
+// last applied configuration of kubectl contain the data as well
+func (a *dryRunAdapter) describeChanges(currentObject kubeObject, object kubeObject) string {
+	newData, sensitive := a.diffData(object)
+	newLabels, newAnnotations := metadataForDiff(object)
+	var currentData, currentLabels, currentAnnotations map[string]string
+	if currentObject != nil {
+		currentData, _ = a.diffData(currentObject)
+		currentLabels, currentAnnotations = metadataForDiff(currentObject)
+	}
+	changes := diffKeys(currentData, newData, sensitive)
+	changes = append(changes, diffKeys(currentAnnotations, newAnnotations, sensitive)...)
+	changes = append(changes, diffKeys(currentLabels, newLabels, false)...)
+	if len(changes) == 0 {
+		return "no changed keys"
+	}
+	return strings.Join(changes, ", ")
+}
+
+// labels and annotations of an object, keyed by "label <key>" and "annotation <key>"
+func metadataForDiff(object kubeObject) (map[string]string, map[string]string) {
+	labels := make(map[string]string)
+	for k, v := range object.GetLabels() {
+		labels["label "+k] = v
+	}
+	annotations := make(map[string]string)
+	for k, v := range object.GetAnnotations() {
+		annotations["annotation "+k] = v
+	}
+	return labels, annotations
+}
+
+// returns the added (+), removed (-) and changed (~) keys between 2 maps, sorted by key
+func diffKeys(oldData map[string]string, newData map[string]string, sensitive bool) []string {
+	value := func(v string) string {
+		if sensitive {
+			return "***"
+		}
+		return fmt.Sprintf("%q", v)
+	}
+	keys := make([]string, 0, len(oldData)+len(newData))
+	for k := range oldData {
+		keys = append(keys, k)
+	}
+	for k := range newData {
+		keys = appendIfMissing(keys, k)
+	}
+	sort.Strings(keys)
+
+	changes := make([]string, 0)
+	for _, k := range keys {
+		oldValue, inOld := oldData[k]
+		newValue, inNew := newData[k]
+		switch {
+		case !inOld:
+			changes = append(changes, fmt.Sprintf("+%v: %v", k, value(newValue)))
+		case !inNew:
+			changes = append(changes, "-"+k)
+		case oldValue != newValue:
+			changes = append(changes, fmt.Sprintf("~%v: %v -> %v", k, value(oldValue), value(newValue)))
+		}
+	}
+	return changes
+}
+
+// renders a value of unstructured data for diffKeys
+func jsonValue(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(data)
+}
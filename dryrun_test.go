@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestDryRunAdapter(t *testing.T) {
+	defer func(dryRun bool) { configDryRun = dryRun }(configDryRun)
+	configDryRun = true
+	hook := logtest.NewGlobal()
+	defer hook.Reset()
+	lastApplied := `{"data":{"key":"c3VwZXJzZWNyZXQ="}}`
+
+	objects := []testObject{
+		{namespace: "default", name: "app", annotations: map[string]string{REPLICATE_REGEX: "ns-[0-9]", "team": "payments", LAST_APPLIED_CONFIGURATION: lastApplied}, data: map[string]string{"key": "new", "added": "value"}},
+		// replica created by a previous version that copied the last applied configuration
+		{namespace: "ns-1", name: "app", annotations: map[string]string{REPLICATED_ANNOTATION: "default/app", REPLICATED_FROM_UID_ANNOTATION: "", "team": "payments", LAST_APPLIED_CONFIGURATION: lastApplied}, data: map[string]string{"key": "old", "removed": "value"}},
+		{namespace: "other", name: "orphan", annotations: map[string]string{REPLICATED_ANNOTATION: "default/orphan"}, data: map[string]string{"key": "value"}},
+	}
+	expected := map[string][]string{
+		"secret": {
+			"[dry-run] Would create ns-2/app: +data.added: ***, +data.key: ***, +annotation resource-replicator/replicated-from: ***, +annotation resource-replicator/replicated-from-uid: ***, +annotation team: ***",
+			"[dry-run] Would update ns-1/app: +data.added: ***, ~data.key: *** -> ***, -data.removed, -annotation kubectl.kubernetes.io/last-applied-configuration",
+			"[dry-run] Would delete other/orphan",
+		},
+		"configmap": {
+			"[dry-run] Would create ns-2/app: +data.added: \"value\", +data.key: \"new\", +annotation resource-replicator/replicated-from: \"default/app\", +annotation resource-replicator/replicated-from-uid: \"\", +annotation team: \"payments\"",
+			"[dry-run] Would update ns-1/app: +data.added: \"value\", ~data.key: \"old\" -> \"new\", -data.removed, -annotation kubectl.kubernetes.io/last-applied-configuration",
+			"[dry-run] Would delete other/orphan",
+		},
+	}
+
+	for kind, newTestAdapter := range testAdapters {
+		t.Run(kind, func(t *testing.T) {
+			hook.Reset()
+			a := newTestAdapter(objects)
+			adapter := newDryRunAdapter(a.adapter)
+			kubeObjects := []kubeObject{a.newObject(objects[0]), a.newObject(objects[1]), a.newObject(objects[2])}
+			if result := processResources(adapter, record.NewFakeRecorder(10), newTestNamespaces("default", "ns-1", "ns-2"), kubeObjects); len(result.errors) > 0 {
+				t.Fatalf("unexpected replication errors: %v", result.errors)
+			}
+			if actions := a.clientSet.Actions(); len(actions) > 0 {
+				t.Errorf("expected no api calls in dry-run mode, got %v", actions)
+			}
+
+			messages := make([]string, 0)
+			for _, entry := range hook.AllEntries() {
+				if strings.HasPrefix(entry.Message, "[dry-run]") {
+					message := fmt.Sprintf("%v %v/%v", entry.Message, entry.Data[LOG_FIELD_TARGET_NAMESPACE], entry.Data[LOG_FIELD_TARGET_NAME])
+					if changes, ok := entry.Data[LOG_FIELD_CHANGES]; ok {
+						message += fmt.Sprintf(": %v", changes)
+					}
+					messages = append(messages, message)
+				}
+			}
+			for _, message := range messages {
+				if strings.Contains(message, "c3VwZXJzZWNyZXQ=") {
+					t.Errorf("expected the last applied configuration to not be logged, got %q", message)
+				}
+			}
+			for _, message := range expected[kind] {
+				if !arrayContains(messages, message) {
+					t.Errorf("expected dry-run log %q, got %v", message, cmp.Diff(expected[kind], messages))
+				}
+			}
+		})
+	}
+}
+
+func TestDryRunAdapterRecreate(t *testing.T) {
+	defer func(dryRun bool, serverDryRun bool, recreateReplicas bool) {
+		configDryRun, configServerDryRun, configRecreateReplicas = dryRun, serverDryRun, recreateReplicas
+	}(configDryRun, configServerDryRun, configRecreateReplicas)
+	configDryRun, configServerDryRun, configRecreateReplicas = true, true, true
+	hook := logtest.NewGlobal()
+	defer hook.Reset()
+
+	immutable := true
+	source := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app", Annotations: map[string]string{REPLICATE_REGEX: "ns-1"}},
+		Immutable:  &immutable,
+		Data:       map[string][]byte{"key": []byte("new")},
+	}
+	replica := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns-1", Name: "app", Annotations: map[string]string{REPLICATED_ANNOTATION: "default/app"}},
+		Immutable:  &immutable,
+		Data:       map[string][]byte{"key": []byte("old")},
+	}
+	clientSet := fake.NewSimpleClientset(source, replica)
+	adapter := newDryRunAdapter(newSecretAdapter(clientSet, informers.NewSharedInformerFactory(clientSet, 0)))
+
+	result := processResources(adapter, record.NewFakeRecorder(10), newTestNamespaces("default", "ns-1"), []kubeObject{source, replica})
+	if len(result.errors) > 0 {
+		t.Fatalf("unexpected replication errors: %v", result.errors)
+	}
+	// the delete is validated by the api server, the create is only logged as the replica is not deleted in dry-run
+	verbs := make([]string, 0)
+	for _, action := range clientSet.Actions() {
+		verbs = append(verbs, action.GetVerb())
+	}
+	if !arrayContains(verbs, "delete") || arrayContains(verbs, "create") {
+		t.Errorf("expected only the delete to be sent to the api server, got api calls %v", verbs)
+	}
+	messages := make([]string, 0)
+	for _, entry := range hook.AllEntries() {
+		messages = append(messages, entry.Message)
+	}
+	if !arrayContains(messages, "[dry-run] Would delete") || !arrayContains(messages, "[dry-run] Would create") {
+		t.Errorf("expected the recreate to be logged as a delete and a create, got %v", messages)
+	}
+}
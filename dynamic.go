@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+
+	"github.com/google/go-cmp/cmp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+// top level fields of an unstructured object that are not replicated data
+var unstructuredMetadataFields = []string{"apiVersion", "kind", "metadata", "status"}
+
+// resourceAdapter implementation for any namespaced resource, using the dynamic client and unstructured objects
+type unstructuredAdapter struct {
+	dynamicClient    dynamic.Interface
+	gvr              schema.GroupVersionResource
+	resourceInformer cache.SharedIndexInformer
+	resourceLister   cache.GenericLister
+}
+
+func newUnstructuredAdapter(dynamicClient dynamic.Interface, informerFactory dynamicinformer.DynamicSharedInformerFactory, gvr schema.GroupVersionResource) *unstructuredAdapter {
+	resourceInformer := informerFactory.ForResource(gvr)
+	return &unstructuredAdapter{
+		dynamicClient:    dynamicClient,
+		gvr:              gvr,
+		resourceInformer: resourceInformer.Informer(),
+		resourceLister:   resourceInformer.Lister(),
+	}
+}
+
+// kind is the group resource (e.g. networkpolicies.networking.k8s.io)
+func (a *unstructuredAdapter) kind() string {
+	return a.gvr.GroupResource().String()
+}
+
+func (a *unstructuredAdapter) informer() cache.SharedIndexInformer {
+	return a.resourceInformer
+}
+
+// Get all objects of the resource from all namespaces
+func (a *unstructuredAdapter) list() ([]kubeObject, error) {
+	resources, err := a.resourceLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	objects := make([]kubeObject, 0, len(resources))
+	for _, resource := range resources {
+		objects = append(objects, resource.(*unstructured.Unstructured))
+	}
+	return objects, nil
+}
+
+func (a *unstructuredAdapter) get(namespace string, name string) (kubeObject, error) {
+	resource, err := a.resourceLister.ByNamespace(namespace).Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return resource.(*unstructured.Unstructured), nil
+}
+
+func (a *unstructuredAdapter) create(object kubeObject) error {
+	_, err := a.dynamicClient.Resource(a.gvr).Namespace(object.GetNamespace()).Create(context.TODO(), object.(*unstructured.Unstructured), metav1.CreateOptions{DryRun: getServerDryRun()})
+	return err
+}
+
+func (a *unstructuredAdapter) update(object kubeObject) error {
+	_, err := a.dynamicClient.Resource(a.gvr).Namespace(object.GetNamespace()).Update(context.TODO(), object.(*unstructured.Unstructured), metav1.UpdateOptions{DryRun: getServerDryRun()})
+	return err
+}
+
+func (a *unstructuredAdapter) delete(object kubeObject) error {
+	return a.dynamicClient.Resource(a.gvr).Namespace(object.GetNamespace()).Delete(context.TODO(), object.GetName(), metav1.DeleteOptions{DryRun: getServerDryRun()})
+}
+
+func (a *unstructuredAdapter) patchAnnotations(object kubeObject, annotations map[string]*string) error {
+	patch, err := annotationsMergePatch(annotations)
+	if err != nil {
+		return err
+	}
+	_, err = a.dynamicClient.Resource(a.gvr).Namespace(object.GetNamespace()).Patch(context.TODO(), object.GetName(), types.MergePatchType, patch, metav1.PatchOptions{DryRun: getServerDryRun()})
+	return err
+}
+
+// the replicated data of an unstructured object is every top level field except for its type, metadata and status (e.g. spec, data, rules)
+func (a *unstructuredAdapter) equalData(originalObject kubeObject, replicatedObject kubeObject) bool {
+	return cmp.Equal(getUnstructuredData(originalObject.(*unstructured.Unstructured)), getUnstructuredData(replicatedObject.(*unstructured.Unstructured)))
+}
+
+func (a *unstructuredAdapter) copyData(dst kubeObject, src kubeObject) {
+	dstObject := dst.(*unstructured.Unstructured).Object
+	for field := range getUnstructuredData(dst.(*unstructured.Unstructured)) {
+		delete(dstObject, field)
+	}
+	for field, value := range getUnstructuredData(src.(*unstructured.Unstructured)) {
+		dstObject[field] = runtime.DeepCopyJSONValue(value)
+	}
+}
+
+// every top level field except for the immutable field is pulled, the target keeps its own immutable field
+func (a *unstructuredAdapter) equalPullData(sourceObject kubeObject, targetObject kubeObject) bool {
+	sourceData := getUnstructuredData(sourceObject.(*unstructured.Unstructured))
+	targetData := getUnstructuredData(targetObject.(*unstructured.Unstructured))
+	delete(sourceData, "immutable")
+	delete(targetData, "immutable")
+	return cmp.Equal(sourceData, targetData)
+}
+
+func (a *unstructuredAdapter) copyPullData(dst kubeObject, src kubeObject) {
+	dstObject := dst.(*unstructured.Unstructured).Object
+	immutable, hasImmutable := dstObject["immutable"]
+	a.copyData(dst, src)
+	delete(dstObject, "immutable")
+	if hasImmutable {
+		dstObject["immutable"] = immutable
+	}
+}
+
+// objects with a top level immutable field (e.g. secrets and configmaps) can only be deleted once it is set
+func (a *unstructuredAdapter) requiresRecreate(replicatedObject kubeObject, copiedObject kubeObject) bool {
+	immutable, _, _ := unstructured.NestedBool(replicatedObject.(*unstructured.Unstructured).Object, "immutable")
+	return immutable && !a.equalData(replicatedObject, copiedObject)
+}
+
+func (a *unstructuredAdapter) recreate(replicatedObject kubeObject, copiedObject kubeObject) (string, error) {
+	return recreateObject(a, replicatedObject, copiedObject)
+}
+
+// the keys of the data, stringData and binaryData fields are filtered, other resources do not have key-value data
+func (a *unstructuredAdapter) filterData(object kubeObject, keyFilter func(key string) bool) {
+	for _, field := range []string{"data", "stringData", "binaryData"} {
+		if data, ok := object.(*unstructured.Unstructured).Object[field].(map[string]interface{}); ok {
+			filterMap(data, keyFilter)
+		}
+	}
+}
+
+// the top level fields are rendered as json
+func (a *unstructuredAdapter) diffData(object kubeObject) (map[string]string, bool) {
+	data := make(map[string]string)
+	for field, value := range getUnstructuredData(object.(*unstructured.Unstructured)) {
+		data[field] = jsonValue(value)
+	}
+	return data, false
+}
+
+// returns the top level fields of an unstructured object that are replicated
+func getUnstructuredData(object *unstructured.Unstructured) map[string]interface{} {
+	data := make(map[string]interface{})
+	for field, value := range object.Object {
+		if !arrayContains(unstructuredMetadataFields, field) {
+			data[field] = value
+		}
+	}
+	return data
+}
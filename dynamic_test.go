@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+var testNetworkPolicyResource = schema.GroupVersionResource{Group: "networking.k8s.io", Version: "v1", Resource: "networkpolicies"}
+
+func newTestNetworkPolicy(namespace string, name string, annotations map[string]interface{}, policyTypes ...interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "networking.k8s.io/v1",
+		"kind":       "NetworkPolicy",
+		"metadata": map[string]interface{}{
+			"namespace":       namespace,
+			"name":            name,
+			"annotations":     annotations,
+			"uid":             "0c4ad2d6-5f4c-4a4f-9a4a-5a9f4b0a1b2c",
+			"resourceVersion": "42",
+		},
+		"spec": map[string]interface{}{
+			"podSelector": map[string]interface{}{},
+			"policyTypes": policyTypes,
+		},
+		"status": map[string]interface{}{
+			"conditions": []interface{}{},
+		},
+	}}
+}
+
+func newTestUnstructuredAdapter(objects ...runtime.Object) (*unstructuredAdapter, *dynamicfake.FakeDynamicClient) {
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		testNetworkPolicyResource: "NetworkPolicyList",
+	}, objects...)
+	informerFactory := dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, 0)
+	return newUnstructuredAdapter(dynamicClient, informerFactory, testNetworkPolicyResource), dynamicClient
+}
+
+func TestUnstructuredAdapterReplication(t *testing.T) {
+	source := newTestNetworkPolicy("default", "deny-all", map[string]interface{}{REPLICATE_REGEX: "ns-1"}, "Ingress")
+	outdatedReplica := newTestNetworkPolicy("ns-2", "deny-all", map[string]interface{}{REPLICATED_ANNOTATION: "default/deny-all"})
+	source.SetFinalizers([]string{"example.com/protect"})
+	adapter, dynamicClient := newTestUnstructuredAdapter(source, outdatedReplica)
+
+	if adapter.kind() != "networkpolicies.networking.k8s.io" {
+		t.Errorf("unexpected kind %v", adapter.kind())
+	}
+
+	// replicate to a new namespace
+	errs := processResources(adapter, record.NewFakeRecorder(10), newTestNamespaces("default", "ns-1", "ns-2"), []kubeObject{source}).errors
+	if len(errs) > 0 {
+		t.Fatalf("unexpected replication errors: %v", errs)
+	}
+	replica, err := dynamicClient.Resource(testNetworkPolicyResource).Namespace("ns-1").Get(context.TODO(), "deny-all", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected replica to exist: %v", err)
+	}
+	if !cmp.Equal(replica.Object["spec"], source.Object["spec"]) {
+		t.Errorf("unexpected replica spec: %v", cmp.Diff(source.Object["spec"], replica.Object["spec"]))
+	}
+	if _, ok := replica.Object["status"]; ok {
+		t.Error("expected status to be stripped from the replica")
+	}
+	if replica.GetUID() != "" {
+		t.Errorf("expected uid to be stripped from the replica, got %v", replica.GetUID())
+	}
+	if len(replica.GetFinalizers()) > 0 {
+		t.Errorf("expected finalizers to be stripped from the replica, got %v", replica.GetFinalizers())
+	}
+	if replica.GetAnnotations()[REPLICATED_ANNOTATION] != "default/deny-all" {
+		t.Errorf("unexpected replicated-from annotation %v", replica.GetAnnotations()[REPLICATED_ANNOTATION])
+	}
+	if _, ok := replica.GetAnnotations()[REPLICATE_REGEX]; ok {
+		t.Error("expected replicate-to annotation to be stripped from the replica")
+	}
+
+	// update an outdated replica after the source regex changed to include its namespace
+	source.SetAnnotations(map[string]string{REPLICATE_REGEX: "ns-2"})
+	errs = processResources(adapter, record.NewFakeRecorder(10), newTestNamespaces("default", "ns-1", "ns-2"), []kubeObject{source, replica, outdatedReplica}).errors
+	if len(errs) > 0 {
+		t.Fatalf("unexpected replication errors: %v", errs)
+	}
+	updatedReplica, err := dynamicClient.Resource(testNetworkPolicyResource).Namespace("ns-2").Get(context.TODO(), "deny-all", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected replica to exist: %v", err)
+	}
+	if !cmp.Equal(updatedReplica.Object["spec"], source.Object["spec"]) {
+		t.Errorf("unexpected replica spec: %v", cmp.Diff(source.Object["spec"], updatedReplica.Object["spec"]))
+	}
+	if _, err := dynamicClient.Resource(testNetworkPolicyResource).Namespace("ns-1").Get(context.TODO(), "deny-all", metav1.GetOptions{}); err == nil {
+		t.Error("expected orphaned replica in ns-1 to be deleted")
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// reasons of the events emitted on source and replicated objects
+const (
+	EVENT_REASON_INVALID_PATTERN         string = "InvalidPattern"
+	EVENT_REASON_REPLICATION_NOT_ALLOWED string = "ReplicationNotAllowed"
+	EVENT_REASON_CONFLICT                string = "Conflict"
+	EVENT_REASON_REPLICATED              string = "Replicated"
+	EVENT_REASON_UPDATED                 string = "Updated"
+	EVENT_REASON_PRUNED                  string = "Pruned"
+	EVENT_REASON_FORBIDDEN               string = "Forbidden"
+	EVENT_REASON_NAMESPACE_TERMINATING   string = "TargetNamespaceTerminating"
+	EVENT_REASON_SOURCE_RECREATED        string = "SourceRecreated"
+)
+
+// Creates an event broadcaster that writes events to the api server, and a recorder that emits events through it.
+// In dry-run mode the events are only logged
+func newEventRecorder(clientSet kubernetes.Interface) (record.EventBroadcaster, record.EventRecorder) {
+	eventBroadcaster := record.NewBroadcaster()
+	if configDryRun {
+		eventBroadcaster.StartLogging(func(format string, args ...interface{}) {
+			log.Infof("[dry-run] Would emit event: "+format, args...)
+		})
+	} else {
+		eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientSet.CoreV1().Events("")})
+	}
+	recorder := eventBroadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "resource-replicator"})
+	return eventBroadcaster, recorder
+}
+
+// Emits an event on the source object for the outcome of replicating it to the target namespace:
+// a Normal event when its replica is created or updated, and a Warning event when the replicator is not allowed to change it
+func recordReplicationEvent(recorder record.EventRecorder, object kubeObject, namespace string, action string, err error) {
+	if err != nil {
+		if errors.IsForbidden(err) {
+			recorder.Eventf(object, v1.EventTypeWarning, EVENT_REASON_FORBIDDEN, "Not allowed to replicate to namespace %v: %v", namespace, err)
+		}
+		return
+	}
+	switch action {
+	case ACTION_CREATE:
+		recorder.Eventf(object, v1.EventTypeNormal, EVENT_REASON_REPLICATED, "Replicated to namespace %v", namespace)
+	case ACTION_UPDATE:
+		recorder.Eventf(object, v1.EventTypeNormal, EVENT_REASON_UPDATED, "Updated the replica in namespace %v", namespace)
+	case ACTION_RECREATE:
+		recorder.Eventf(object, v1.EventTypeNormal, EVENT_REASON_UPDATED, "Recreated the replica in namespace %v as it could not be updated", namespace)
+	}
+}
+
+// Emits an event on the source object when its orphaned replica is deleted, or is not allowed to be deleted
+func recordPruneEvent(recorder record.EventRecorder, object kubeObject, replica kubeObject, action string, err error) {
+	if err != nil {
+		if errors.IsForbidden(err) {
+			recorder.Eventf(object, v1.EventTypeWarning, EVENT_REASON_FORBIDDEN, "Not allowed to delete the replica %v in namespace %v: %v", replica.GetName(), replica.GetNamespace(), err)
+		}
+		return
+	}
+	if action == ACTION_DELETE {
+		recorder.Eventf(object, v1.EventTypeNormal, EVENT_REASON_PRUNED, "Deleted the replica %v in namespace %v that is no longer replicated to", replica.GetName(), replica.GetNamespace())
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestProcessResourcesEvents(t *testing.T) {
+	secrets := schema.GroupResource{Resource: "secrets"}
+	terminating := &errors.StatusError{ErrStatus: metav1.Status{
+		Status:  metav1.StatusFailure,
+		Code:    403,
+		Reason:  metav1.StatusReasonForbidden,
+		Details: &metav1.StatusDetails{Causes: []metav1.StatusCause{{Type: v1.NamespaceTerminatingCause}}},
+	}}
+	tests := []struct {
+		name      string
+		objects   []testObject
+		createErr error
+		expected  []string
+	}{
+		{
+			name: "replicated",
+			objects: []testObject{
+				{namespace: "default", name: "app", annotations: map[string]string{REPLICATE_REGEX: "ns-1"}, data: map[string]string{"key": "value"}},
+			},
+			expected: []string{"Normal Replicated Replicated to namespace ns-1"},
+		},
+		{
+			name: "updated",
+			objects: []testObject{
+				{namespace: "default", name: "app", annotations: map[string]string{REPLICATE_REGEX: "ns-1"}, data: map[string]string{"key": "new"}},
+				{namespace: "ns-1", name: "app", annotations: map[string]string{REPLICATED_ANNOTATION: "default/app"}, data: map[string]string{"key": "old"}},
+			},
+			expected: []string{"Normal Updated Updated the replica in namespace ns-1"},
+		},
+		{
+			name: "pruned",
+			objects: []testObject{
+				{namespace: "default", name: "app", annotations: map[string]string{REPLICATE_REGEX: "ns-2"}, data: map[string]string{"key": "value"}},
+				{namespace: "ns-1", name: "app", annotations: map[string]string{REPLICATED_ANNOTATION: "default/app"}, data: map[string]string{"key": "value"}},
+				{namespace: "ns-2", name: "app", annotations: map[string]string{REPLICATED_ANNOTATION: "default/app", REPLICATED_FROM_UID_ANNOTATION: ""}, data: map[string]string{"key": "value"}},
+			},
+			expected: []string{"Normal Pruned Deleted the replica app in namespace ns-1 that is no longer replicated to"},
+		},
+		{
+			name: "forbidden",
+			objects: []testObject{
+				{namespace: "default", name: "app", annotations: map[string]string{REPLICATE_REGEX: "ns-1"}, data: map[string]string{"key": "value"}},
+			},
+			createErr: errors.NewForbidden(secrets, "app", fmt.Errorf("missing permission")),
+			expected:  []string{`Warning Forbidden Not allowed to replicate to namespace ns-1: secrets "app" is forbidden: missing permission`},
+		},
+		{
+			name: "target namespace terminating",
+			objects: []testObject{
+				{namespace: "default", name: "app", annotations: map[string]string{REPLICATE_REGEX: "ns-1"}, data: map[string]string{"key": "value"}},
+			},
+			createErr: terminating,
+			expected:  []string{"Warning TargetNamespaceTerminating Not replicating to namespace ns-1 as it is terminating"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := testAdapters["secret"](test.objects)
+			if test.createErr != nil {
+				a.clientSet.PrependReactor("create", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+					return true, nil, test.createErr
+				})
+			}
+			objects := make([]kubeObject, 0, len(test.objects))
+			for _, object := range test.objects {
+				objects = append(objects, a.newObject(object))
+			}
+			recorder := record.NewFakeRecorder(10)
+			processResources(a.adapter, recorder, newTestNamespaces("default", "ns-1", "ns-2"), objects)
+
+			close(recorder.Events)
+			events := make([]string, 0, len(test.expected))
+			for event := range recorder.Events {
+				events = append(events, event)
+			}
+			if diff := cmp.Diff(test.expected, events); diff != "" {
+				t.Errorf("unexpected events (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
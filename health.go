@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// tracks the progress of the controller for the liveness and readiness probes
+type healthStatus struct {
+	mu sync.Mutex
+	// time the controller started, used as the last loop until the first full reconciliation finishes
+	started time.Time
+	// whether the initial full reconciliation after the informer caches synced has finished
+	synced bool
+	// time the last full reconciliation finished
+	lastLoop time.Time
+}
+
+func newHealthStatus(now time.Time) *healthStatus {
+	return &healthStatus{started: now}
+}
+
+// records a finished full reconciliation, the first one completes the initial sync
+func (h *healthStatus) loopFinished(now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.synced = true
+	h.lastLoop = now
+}
+
+// the controller is ready once the initial sync has completed
+func (h *healthStatus) ready() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.synced {
+		return fmt.Errorf("initial sync has not completed")
+	}
+	return nil
+}
+
+// the controller is live as long as a full reconciliation finished within maxAge,
+// a hung api call or worker stops the loops and fails the liveness probe
+func (h *healthStatus) live(now time.Time, maxAge time.Duration) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	last := h.lastLoop
+	if !h.synced {
+		last = h.started
+	}
+	if age := now.Sub(last); age > maxAge {
+		return fmt.Errorf("no reconcile loop finished in the last %v", age.Round(time.Second))
+	}
+	return nil
+}
+
+// returns a probe handler that fails with 503 when check returns an error
+func probeHandler(check func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := check(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	}
+}
+
+// Serves the liveness probe on /healthz and the readiness probe on /readyz of the address in the background.
+// Liveness fails if no full reconciliation finished within livenessThreshold times the loop duration.
+func serveHealth(address string, health *healthStatus, loopDuration time.Duration, livenessThreshold int) {
+	maxAge := time.Duration(livenessThreshold) * loopDuration
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", probeHandler(func() error { return health.live(time.Now(), maxAge) }))
+	mux.Handle("/readyz", probeHandler(health.ready))
+	go func() {
+		log.Infof("Serving health probes on %v", address)
+		if err := http.ListenAndServe(address, mux); err != nil {
+			log.WithError(err).Error("Error serving health probes")
+		}
+	}()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthStatus(t *testing.T) {
+	started := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name          string
+		loopFinished  *time.Time
+		now           time.Time
+		expectedReady bool
+		expectedLive  bool
+	}{
+		{
+			name:          "starting",
+			now:           started.Add(time.Second),
+			expectedReady: false,
+			expectedLive:  true,
+		},
+		{
+			name:          "initial sync hangs",
+			now:           started.Add(time.Minute),
+			expectedReady: false,
+			expectedLive:  false,
+		},
+		{
+			name:          "recent loop",
+			loopFinished:  timePointer(started.Add(time.Minute)),
+			now:           started.Add(time.Minute + 10*time.Second),
+			expectedReady: true,
+			expectedLive:  true,
+		},
+		{
+			name:          "loop hangs after the initial sync",
+			loopFinished:  timePointer(started.Add(time.Minute)),
+			now:           started.Add(2 * time.Minute),
+			expectedReady: true,
+			expectedLive:  false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			health := newHealthStatus(started)
+			if test.loopFinished != nil {
+				health.loopFinished(*test.loopFinished)
+			}
+			if err := health.ready(); (err == nil) != test.expectedReady {
+				t.Errorf("expected ready to be %v, got error %v", test.expectedReady, err)
+			}
+			if err := health.live(test.now, 30*time.Second); (err == nil) != test.expectedLive {
+				t.Errorf("expected live to be %v, got error %v", test.expectedLive, err)
+			}
+		})
+	}
+}
+
+func TestProbeHandler(t *testing.T) {
+	health := newHealthStatus(time.Now())
+	handler := probeHandler(health.ready)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %v before the initial sync, got %v", http.StatusServiceUnavailable, recorder.Code)
+	}
+
+	health.loopFinished(time.Now())
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("expected status %v after the initial sync, got %v", http.StatusOK, recorder.Code)
+	}
+}
+
+func timePointer(t time.Time) *time.Time {
+	return &t
+}
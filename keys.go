@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// matches a key of the data of an object
+type keyPattern func(key string) bool
+
+// Compiles the comma separated key patterns of an include-keys or exclude-keys annotation.
+// Patterns wrapped in slashes (e.g. "/^ro-.*$/") are regular expressions, other patterns are globs (e.g. "ro-*")
+func compileKeyPatterns(annotation string) ([]keyPattern, error) {
+	patterns := make([]keyPattern, 0)
+	for _, pattern := range strings.Split(annotation, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if len(pattern) > 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+			regex, err := regexp.Compile(pattern[1 : len(pattern)-1])
+			if err != nil {
+				return nil, err
+			}
+			patterns = append(patterns, regex.MatchString)
+			continue
+		}
+		glob := pattern
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, fmt.Errorf("invalid glob %q: %w", glob, err)
+		}
+		patterns = append(patterns, func(key string) bool {
+			matched, _ := path.Match(glob, key)
+			return matched
+		})
+	}
+	return patterns, nil
+}
+
+func matchesAnyKeyPattern(patterns []keyPattern, key string) bool {
+	for _, pattern := range patterns {
+		if pattern(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns a function that checks if a key of the data of the object is replicated, from its include-keys and exclude-keys annotations.
+// Without include-keys every key is included, and keys matching exclude-keys are never replicated.
+// Returns nil if every key is replicated
+func getKeyFilter(obj metav1.Object) (func(key string) bool, error) {
+	annotations := obj.GetAnnotations()
+	includeAnnotation, hasInclude := annotations[INCLUDE_KEYS]
+	excludeAnnotation, hasExclude := annotations[EXCLUDE_KEYS]
+	if !hasInclude && !hasExclude {
+		return nil, nil
+	}
+	includePatterns, err := compileKeyPatterns(includeAnnotation)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %v annotation: %w", INCLUDE_KEYS, err)
+	}
+	excludePatterns, err := compileKeyPatterns(excludeAnnotation)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %v annotation: %w", EXCLUDE_KEYS, err)
+	}
+	return func(key string) bool {
+		if hasInclude && !matchesAnyKeyPattern(includePatterns, key) {
+			return false
+		}
+		return !matchesAnyKeyPattern(excludePatterns, key)
+	}, nil
+}
+
+// Returns a copy of the object that only has the replicated keys in its data, the object itself if every key is replicated
+func filterKeys(adapter resourceAdapter, object kubeObject) (kubeObject, error) {
+	keyFilter, err := getKeyFilter(object)
+	if err != nil || keyFilter == nil {
+		return object, err
+	}
+	filteredObject := object.DeepCopyObject().(kubeObject)
+	adapter.filterData(filteredObject, keyFilter)
+	return filteredObject, nil
+}
+
+// removes the keys of a data map that are not replicated
+func filterMap[V any](data map[string]V, keyFilter func(key string) bool) {
+	for key := range data {
+		if !keyFilter(key) {
+			delete(data, key)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetKeyFilter(t *testing.T) {
+	keys := []string{"ro-user", "ro-password", "admin-user", "admin-password", "ca.crt"}
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    []string
+		expectError bool
+	}{
+		{
+			name:     "every key without annotations",
+			expected: keys,
+		},
+		{
+			name:        "include glob",
+			annotations: map[string]string{INCLUDE_KEYS: "ro-*, *.crt"},
+			expected:    []string{"ro-user", "ro-password", "ca.crt"},
+		},
+		{
+			name:        "exclude regex",
+			annotations: map[string]string{EXCLUDE_KEYS: "/^admin-/"},
+			expected:    []string{"ro-user", "ro-password", "ca.crt"},
+		},
+		{
+			name:        "exclude takes precedence over include",
+			annotations: map[string]string{INCLUDE_KEYS: "*-user,*-password", EXCLUDE_KEYS: "admin-*"},
+			expected:    []string{"ro-user", "ro-password"},
+		},
+		{
+			name:        "invalid glob",
+			annotations: map[string]string{INCLUDE_KEYS: "ro-["},
+			expectError: true,
+		},
+		{
+			name:        "invalid regex",
+			annotations: map[string]string{EXCLUDE_KEYS: "/(admin/"},
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			keyFilter, err := getKeyFilter(&metav1.ObjectMeta{Annotations: test.annotations})
+			if (err != nil) != test.expectError {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if test.expectError {
+				return
+			}
+			for _, key := range keys {
+				included := keyFilter == nil || keyFilter(key)
+				if included != arrayContains(test.expected, key) {
+					t.Errorf("unexpected inclusion of key %v: %v", key, included)
+				}
+			}
+		})
+	}
+}
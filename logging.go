@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// formats of the logs, set with CONFIG_LOG_FORMAT
+const (
+	LOG_FORMAT_TEXT string = "text"
+	LOG_FORMAT_JSON string = "json"
+)
+
+// field names of the structured logs, errors use the "error" field of logrus
+const (
+	LOG_FIELD_KIND             string = "kind"
+	LOG_FIELD_SOURCE_NAMESPACE string = "source_namespace"
+	LOG_FIELD_SOURCE_NAME      string = "source_name"
+	LOG_FIELD_TARGET_NAMESPACE string = "target_namespace"
+	LOG_FIELD_TARGET_NAME      string = "target_name"
+	LOG_FIELD_ACTION           string = "action"
+	// duration in seconds
+	LOG_FIELD_DURATION string = "duration"
+	LOG_FIELD_CHANGES  string = "changes"
+	LOG_FIELD_DRY_RUN  string = "dry_run"
+)
+
+// Sets the format and level of the logs, CONFIG_DEBUG takes precedence over the level for backwards compatibility
+func setupLogging(format string, level string, debug bool) error {
+	switch format {
+	case LOG_FORMAT_TEXT:
+		log.SetFormatter(&log.TextFormatter{
+			FullTimestamp:          true,
+			DisableLevelTruncation: true,
+		})
+	case LOG_FORMAT_JSON:
+		log.SetFormatter(&log.JSONFormatter{TimestampFormat: time.RFC3339Nano})
+	default:
+		return fmt.Errorf("invalid log format %q, expected %v or %v", format, LOG_FORMAT_TEXT, LOG_FORMAT_JSON)
+	}
+	logLevel, err := log.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	if debug && logLevel < log.DebugLevel {
+		logLevel = log.DebugLevel
+	}
+	log.SetLevel(logLevel)
+	return nil
+}
+
+// returns a log entry with the kind and the namespace and name of the source object
+func sourceLog(adapter resourceAdapter, object metav1.Object) *log.Entry {
+	return log.WithFields(log.Fields{
+		LOG_FIELD_KIND:             adapter.kind(),
+		LOG_FIELD_SOURCE_NAMESPACE: object.GetNamespace(),
+		LOG_FIELD_SOURCE_NAME:      object.GetName(),
+	})
+}
+
+// returns a log entry with the kind and the namespace and name of the object in a target namespace,
+// together with the namespace and name of its source object if it is a replicated object
+func targetLog(adapter resourceAdapter, object metav1.Object) *log.Entry {
+	fields := log.Fields{
+		LOG_FIELD_KIND:             adapter.kind(),
+		LOG_FIELD_TARGET_NAMESPACE: object.GetNamespace(),
+		LOG_FIELD_TARGET_NAME:      object.GetName(),
+	}
+	if isReplicatedObject(object) {
+		fields[LOG_FIELD_SOURCE_NAMESPACE], fields[LOG_FIELD_SOURCE_NAME] = getReplicatedSource(object)
+	}
+	return log.WithFields(fields)
+}
+
+// logs the action taken on an object in a target namespace together with how long it took, nothing is logged without an action
+func logAction(entry *log.Entry, action string, start time.Time) {
+	if action == ACTION_NONE {
+		return
+	}
+	entry = entry.WithFields(log.Fields{LOG_FIELD_ACTION: action, LOG_FIELD_DURATION: time.Since(start).Seconds()})
+	if configDryRun {
+		entry = entry.WithField(LOG_FIELD_DRY_RUN, true)
+	}
+	entry.Info("Reconciled replica")
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSetupLogging(t *testing.T) {
+	defer func(formatter log.Formatter, level log.Level) {
+		log.SetFormatter(formatter)
+		log.SetLevel(level)
+	}(log.StandardLogger().Formatter, log.GetLevel())
+
+	tests := []struct {
+		name              string
+		format            string
+		level             string
+		debug             bool
+		expectedFormatter log.Formatter
+		expectedLevel     log.Level
+		expectError       bool
+	}{
+		{name: "text", format: LOG_FORMAT_TEXT, level: "info", expectedFormatter: &log.TextFormatter{}, expectedLevel: log.InfoLevel},
+		{name: "json", format: LOG_FORMAT_JSON, level: "warn", expectedFormatter: &log.JSONFormatter{}, expectedLevel: log.WarnLevel},
+		{name: "debug flag raises the level", format: LOG_FORMAT_JSON, level: "warn", debug: true, expectedFormatter: &log.JSONFormatter{}, expectedLevel: log.DebugLevel},
+		{name: "debug flag keeps a lower level", format: LOG_FORMAT_TEXT, level: "trace", debug: true, expectedFormatter: &log.TextFormatter{}, expectedLevel: log.TraceLevel},
+		{name: "invalid format", format: "xml", level: "info", expectError: true},
+		{name: "invalid level", format: LOG_FORMAT_TEXT, level: "verbose", expectError: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := setupLogging(test.format, test.level, test.debug)
+			if test.expectError {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			switch test.expectedFormatter.(type) {
+			case *log.TextFormatter:
+				if _, ok := log.StandardLogger().Formatter.(*log.TextFormatter); !ok {
+					t.Errorf("expected a text formatter, got %T", log.StandardLogger().Formatter)
+				}
+			case *log.JSONFormatter:
+				if _, ok := log.StandardLogger().Formatter.(*log.JSONFormatter); !ok {
+					t.Errorf("expected a json formatter, got %T", log.StandardLogger().Formatter)
+				}
+			}
+			if log.GetLevel() != test.expectedLevel {
+				t.Errorf("expected level %v, got %v", test.expectedLevel, log.GetLevel())
+			}
+		})
+	}
+}
+
+func TestLogAction(t *testing.T) {
+	hook := logtest.NewGlobal()
+	defer hook.Reset()
+	adapter := testAdapters["secret"](nil).adapter
+	replica := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "ns-1", Name: "app", Annotations: map[string]string{REPLICATED_ANNOTATION: "default/source"}}}
+
+	logAction(targetLog(adapter, replica), ACTION_NONE, time.Now())
+	if len(hook.AllEntries()) > 0 {
+		t.Fatalf("expected nothing to be logged without an action, got %v", hook.AllEntries())
+	}
+
+	logAction(targetLog(adapter, replica), ACTION_UPDATE, time.Now())
+	entry := hook.LastEntry()
+	if entry == nil {
+		t.Fatal("expected the action to be logged")
+	}
+	expected := map[string]interface{}{
+		LOG_FIELD_KIND:             "secret",
+		LOG_FIELD_SOURCE_NAMESPACE: "default",
+		LOG_FIELD_SOURCE_NAME:      "source",
+		LOG_FIELD_TARGET_NAMESPACE: "ns-1",
+		LOG_FIELD_TARGET_NAME:      "app",
+		LOG_FIELD_ACTION:           ACTION_UPDATE,
+	}
+	for field, value := range expected {
+		if entry.Data[field] != value {
+			t.Errorf("expected field %v to be %v, got %v", field, value, entry.Data[field])
+		}
+	}
+	if _, ok := entry.Data[LOG_FIELD_DURATION].(float64); !ok {
+		t.Errorf("expected the duration in seconds, got %v", entry.Data[LOG_FIELD_DURATION])
+	}
+}
+
+func TestReplicationErrorLog(t *testing.T) {
+	hook := logtest.NewGlobal()
+	defer hook.Reset()
+	err := replicationError{kind: "secret", sourceNamespace: "default", sourceName: "app", targetNamespace: "ns-1", err: fmt.Errorf("forbidden")}
+	if err.Error() != "forbidden" {
+		t.Errorf("expected only the wrapped error in the message, got %q", err.Error())
+	}
+
+	(&replicationResult{}).add(err)
+	entry := hook.LastEntry()
+	if entry == nil {
+		t.Fatal("expected the error to be logged")
+	}
+	expected := map[string]interface{}{
+		LOG_FIELD_KIND:             "secret",
+		LOG_FIELD_SOURCE_NAMESPACE: "default",
+		LOG_FIELD_SOURCE_NAME:      "app",
+		LOG_FIELD_TARGET_NAMESPACE: "ns-1",
+	}
+	for field, value := range expected {
+		if entry.Data[field] != value {
+			t.Errorf("expected field %v to be %v, got %v", field, value, entry.Data[field])
+		}
+	}
+	if logged, ok := entry.Data[log.ErrorKey].(error); !ok || logged.Error() != "forbidden" {
+		t.Errorf("expected the wrapped error in the error field, got %v", entry.Data[log.ErrorKey])
+	}
+}
@@ -2,13 +2,16 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
-	"sync"
+	"strings"
+	"syscall"
 	"time"
 
 	log "github.com/sirupsen/logrus"
-	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
@@ -19,15 +22,82 @@ var (
 	// Config
 	configDebug        bool          = false
 	configLoopDuration time.Duration = 10 * time.Second
+	configResources    string        = ""
+	// format of the logs, text or json
+	configLogFormat string = LOG_FORMAT_TEXT
+	// level of the logs, e.g. debug, info or warn
+	configLogLevel string = "info"
+	// comma separated names or regular expressions of namespaces that are never replicated to
+	configExcludeNamespaces string = ""
+	// match the namespace patterns against any substring of the namespace names, instead of the full names
+	configSubstringMatch bool = false
+	// honour the overwrite-existing annotation, adopting existing objects in the target namespaces that are not replicas
+	configAllowOverwriteExisting bool = false
+	// delete and recreate replicated objects that cannot be updated, i.e. a secret with a changed type or an immutable object
+	configRecreateReplicas bool = true
+	// orphan policy of replicated objects whose source object has no orphan-policy annotation
+	configOrphanPolicy string = ORPHAN_POLICY_DELETE
+	// how long a replicated object has to be orphaned before its orphan policy applies
+	configOrphanGracePeriod time.Duration = 30 * time.Second
+	// log the creates, updates and deletes instead of doing them
+	configDryRun bool = false
+	// in dry-run mode, send the requests with the DryRun option of the api server to validate them
+	configServerDryRun bool = false
+	// run a single full reconciliation and exit, instead of running continuously
+	configOnce bool = false
+	// address of the http server serving the prometheus metrics, empty to disable it
+	configMetricsAddress string = ":8080"
+	// address of the http server serving the liveness and readiness probes, empty to disable it
+	configHealthAddress string = ":8081"
+	// the liveness probe fails if no full reconciliation finished within this many times CONFIG_LOOP_DURATION
+	configLivenessThreshold int = 5
+	// queries per second and burst of the requests to the api server, client-go defaults to 5 and 10
+	// which would slow down a full reconciliation that writes many replicas past the liveness threshold
+	configKubeAPIQPS   int = 50
+	configKubeAPIBurst int = 100
 )
 
 const (
-	REPLICATE_REGEX            string = "resource-replicator/replicate-to"
-	REPLICATE_ALL_NAMESPACES   string = "resource-replicator/all-namespaces"
-	REPLICATED_ANNOTATION      string = "resource-replicator/replicated-from"
-	LAST_APPLIED_CONFIGURATION string = "kubectl.kubernetes.io/last-applied-configuration"
+	REPLICATE_REGEX                string = "resource-replicator/replicate-to"
+	REPLICATE_SELECTOR             string = "resource-replicator/replicate-to-selector"
+	REPLICATE_ALL_NAMESPACES       string = "resource-replicator/all-namespaces"
+	EXCLUDE_REGEX                  string = "resource-replicator/exclude-namespaces"
+	EXCLUDE_SELECTOR               string = "resource-replicator/exclude-namespaces-selector"
+	SUBSTRING_MATCH                string = "resource-replicator/substring-match"
+	REPLICATED_ANNOTATION          string = "resource-replicator/replicated-from"
+	REPLICATED_FROM_UID_ANNOTATION string = "resource-replicator/replicated-from-uid"
+	REPLICATE_FROM                 string = "resource-replicator/replicate-from"
+	REPLICATION_ALLOWED_NAMESPACES string = "resource-replicator/replication-allowed-namespaces"
+	OVERWRITE_EXISTING             string = "resource-replicator/overwrite-existing"
+	TARGET_NAME                    string = "resource-replicator/target-name"
+	ORPHAN_POLICY                  string = "resource-replicator/orphan-policy"
+	ORPHANED_AT_ANNOTATION         string = "resource-replicator/orphaned-at"
+	ORPHANED_SINCE_ANNOTATION      string = "resource-replicator/orphaned-since"
+	INCLUDE_KEYS                   string = "resource-replicator/include-keys"
+	EXCLUDE_KEYS                   string = "resource-replicator/exclude-keys"
+	INVALID_PATTERNS_ANNOTATION    string = "resource-replicator/invalid-patterns"
+	REPLICATION_STATUS_ANNOTATION  string = "resource-replicator/replication-status"
+	LAST_APPLIED_CONFIGURATION     string = "kubectl.kubernetes.io/last-applied-configuration"
 )
 
+// annotations that configure the replication of a source object, they are not copied to the replicated objects
+var sourceOnlyAnnotations = []string{
+	REPLICATE_REGEX,
+	REPLICATE_SELECTOR,
+	REPLICATE_ALL_NAMESPACES,
+	EXCLUDE_REGEX,
+	EXCLUDE_SELECTOR,
+	SUBSTRING_MATCH,
+	REPLICATE_FROM,
+	REPLICATION_ALLOWED_NAMESPACES,
+	INVALID_PATTERNS_ANNOTATION,
+	REPLICATION_STATUS_ANNOTATION,
+	OVERWRITE_EXISTING,
+	TARGET_NAME,
+	INCLUDE_KEYS,
+	EXCLUDE_KEYS,
+}
+
 func getKubernetesConfig() *rest.Config {
 	var config *rest.Config
 	home := homedir.HomeDir()
@@ -54,46 +124,101 @@ func getKubernetesConfig() *rest.Config {
 
 func main() {
 	flag.BoolVar(&configDebug, "configDebug", LookupEnvOrBool("CONFIG_DEBUG", configDebug), "show DEBUG logs")
-	flag.DurationVar(&configLoopDuration, "configLoopDuration", LookupEnvOrDuration("CONFIG_LOOP_DURATION", configLoopDuration), "duration string which defines how often namespaces are checked, see https://golang.org/pkg/time/#ParseDuration for more examples")
+	flag.StringVar(&configLogFormat, "configLogFormat", LookupEnvOrString("CONFIG_LOG_FORMAT", configLogFormat), "format of the logs, text or json")
+	flag.StringVar(&configLogLevel, "configLogLevel", LookupEnvOrString("CONFIG_LOG_LEVEL", configLogLevel), "level of the logs: trace, debug, info, warn, error, fatal or panic")
+	flag.DurationVar(&configLoopDuration, "configLoopDuration", LookupEnvOrDuration("CONFIG_LOOP_DURATION", configLoopDuration), "duration string which defines how often all resources are fully reconciled, see https://golang.org/pkg/time/#ParseDuration for more examples")
+
+	flag.StringVar(&configResources, "configResources", LookupEnvOrString("CONFIG_RESOURCES", configResources), "comma separated list of additional namespaced resources to replicate in the <group>/<version>/<resource> format, e.g. networking.k8s.io/v1/networkpolicies,v1/limitranges")
+	flag.StringVar(&configExcludeNamespaces, "configExcludeNamespaces", LookupEnvOrString("CONFIG_EXCLUDE_NAMESPACES", configExcludeNamespaces), "comma separated list of names or regular expressions of namespaces that are never replicated to, e.g. kube-system,kube-public")
+	flag.BoolVar(&configSubstringMatch, "configSubstringMatch", LookupEnvOrBool("CONFIG_SUBSTRING_MATCH", configSubstringMatch), "match namespace patterns against any substring of the namespace names (legacy behaviour), instead of the full names")
+	flag.BoolVar(&configAllowOverwriteExisting, "configAllowOverwriteExisting", LookupEnvOrBool("CONFIG_ALLOW_OVERWRITE_EXISTING", configAllowOverwriteExisting), "honour the overwrite-existing annotation of source objects, adopting existing objects in the target namespaces that are not replicated from them")
+	flag.StringVar(&configOrphanPolicy, "configOrphanPolicy", LookupEnvOrString("CONFIG_ORPHAN_POLICY", configOrphanPolicy), "default policy for replicated objects whose source object is deleted or no longer replicates to them: delete, retain or delete-after=<duration>")
+	flag.DurationVar(&configOrphanGracePeriod, "configOrphanGracePeriod", LookupEnvOrDuration("CONFIG_ORPHAN_GRACE_PERIOD", configOrphanGracePeriod), "how long a replicated object has to be orphaned before its orphan policy applies, protecting replicas from a source object that is missing only briefly")
+	flag.BoolVar(&configDryRun, "configDryRun", LookupEnvOrBool("CONFIG_DRY_RUN", configDryRun), "log the creates, updates and deletes of replicated objects instead of doing them")
+	flag.BoolVar(&configDryRun, "dry-run", configDryRun, "alias of -configDryRun")
+	flag.BoolVar(&configServerDryRun, "configServerDryRun", LookupEnvOrBool("CONFIG_SERVER_DRY_RUN", configServerDryRun), "in dry-run mode, send the requests with the DryRun option to be validated by the api server")
+	flag.BoolVar(&configOnce, "configOnce", LookupEnvOrBool("CONFIG_ONCE", configOnce), "run a single full reconciliation, print a summary and exit with a non-zero code if any replication failed")
+	flag.BoolVar(&configOnce, "once", configOnce, "alias of -configOnce")
+	flag.StringVar(&configMetricsAddress, "configMetricsAddress", LookupEnvOrString("CONFIG_METRICS_ADDRESS", configMetricsAddress), "address of the http server serving the prometheus metrics on /metrics, empty to disable it")
+	flag.StringVar(&configHealthAddress, "configHealthAddress", LookupEnvOrString("CONFIG_HEALTH_ADDRESS", configHealthAddress), "address of the http server serving the liveness probe on /healthz and the readiness probe on /readyz, empty to disable it")
+	flag.IntVar(&configLivenessThreshold, "configLivenessThreshold", LookupEnvOrInt("CONFIG_LIVENESS_THRESHOLD", configLivenessThreshold), "the liveness probe fails if no full reconciliation finished within this many times the loop duration")
+	flag.IntVar(&configKubeAPIQPS, "configKubeAPIQPS", LookupEnvOrInt("CONFIG_KUBE_API_QPS", configKubeAPIQPS), "maximum queries per second to the api server")
+	flag.IntVar(&configKubeAPIBurst, "configKubeAPIBurst", LookupEnvOrInt("CONFIG_KUBE_API_BURST", configKubeAPIBurst), "maximum burst of queries to the api server")
+	flag.BoolVar(&configRecreateReplicas, "configRecreateReplicas", LookupEnvOrBool("CONFIG_RECREATE_REPLICAS", configRecreateReplicas), "delete and recreate replicated objects that cannot be updated, i.e. a secret with a changed type or an immutable object")
 
 	flag.Parse()
 
 	// setup logrus
-	if configDebug {
-		log.SetLevel(log.DebugLevel)
+	if err := setupLogging(configLogFormat, configLogLevel, configDebug); err != nil {
+		log.Fatalf("Invalid logging configuration: %v", err)
 	}
-	log.SetFormatter(&log.TextFormatter{
-		FullTimestamp:          true,
-		DisableLevelTruncation: true,
-	})
 
 	log.Info("Application started")
 	log.Debug("config loop duration: ", configLoopDuration)
+	resources, err := parseGroupVersionResources(configResources)
+	if err != nil {
+		log.Fatalf("Invalid CONFIG_RESOURCES: %v", err)
+	}
+	log.Debug("config resources: ", resources)
+	// like the exclude-namespaces annotation, the default exclusions are compiled with the pattern matching of every source object
+	if _, invalidPatterns := compileNamespacePatterns(configExcludeNamespaces, false); len(invalidPatterns) > 0 {
+		log.Fatalf("Invalid CONFIG_EXCLUDE_NAMESPACES patterns: %v", strings.Join(invalidPatterns, ","))
+	}
+	log.Debug("config exclude namespaces: ", configExcludeNamespaces)
+	log.Debug("config substring match: ", configSubstringMatch)
+	log.Debug("config allow overwrite existing: ", configAllowOverwriteExisting)
+	if _, err := parseOrphanPolicy(configOrphanPolicy); err != nil {
+		log.Fatalf("Invalid CONFIG_ORPHAN_POLICY: %v", err)
+	}
+	if configLivenessThreshold < 1 {
+		log.Fatalf("Invalid CONFIG_LIVENESS_THRESHOLD: %v, it has to be at least 1", configLivenessThreshold)
+	}
+	log.Debug("config orphan policy: ", configOrphanPolicy)
+	log.Debug("config orphan grace period: ", configOrphanGracePeriod)
+	if configDryRun {
+		log.Warn("Running in dry-run mode, nothing is changed in the cluster")
+	}
 
 	// create the clientset
 	config := getKubernetesConfig()
+	config.QPS = float32(configKubeAPIQPS)
+	config.Burst = configKubeAPIBurst
+	log.Debugf("config kube api qps: %v, burst: %v", config.QPS, config.Burst)
 	clientSet, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		panic(err.Error())
 	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		panic(err.Error())
+	}
 
-	for {
-		log.Info("Checking...")
-		allNamespaces := getAllNamespaces(clientSet)
-		loop(clientSet, allNamespaces)
-		log.Debugf("End of loop!")
+	stopCh := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Infof("Received %v signal, stopping...", sig)
+		close(stopCh)
+	}()
 
-		time.Sleep(configLoopDuration)
+	controller := newController(clientSet, dynamicClient, resources, configLoopDuration)
+	if configMetricsAddress != "" && !configOnce {
+		serveMetrics(configMetricsAddress)
 	}
-}
-
-// main loop function that uses goroutines to process secrets and configmaps
-// includes waitGroup to block code execution until the loop function full completes.
-// This is to ensure the loop is fully executed before the loop delay is executed
-func loop(clientSet *kubernetes.Clientset, allNamespaces *v1.NamespaceList) {
-	var wg sync.WaitGroup
-	wg.Add(2)
-	go processSecrets(clientSet, allNamespaces, &wg)
-	go processConfigmaps(clientSet, allNamespaces, &wg)
-	wg.Wait()
+	if configHealthAddress != "" && !configOnce {
+		serveHealth(configHealthAddress, controller.health, configLoopDuration, configLivenessThreshold)
+	}
+	if configOnce {
+		result, err := controller.runOnce(stopCh)
+		if err != nil {
+			log.Fatalf("Error reconciling: %v", err)
+		}
+		fmt.Printf("Summary: %v\n", result)
+		if len(result.errors) > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+	controller.run(stopCh)
 }
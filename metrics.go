@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+// prometheus metrics of the replication, labelled by the kind of the resourceAdapter
+var (
+	replicationActionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "resource_replicator_actions_total",
+		Help: "Number of replicated objects created, updated and deleted",
+	}, []string{"kind", "action"})
+	replicationErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "resource_replicator_errors_total",
+		Help: "Number of failed replications of a source object to a target namespace",
+	}, []string{"kind"})
+	sourceObjectsGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "resource_replicator_source_objects",
+		Help: "Number of source objects in the last full reconciliation",
+	}, []string{"kind"})
+	replicatedObjectsGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "resource_replicator_replicated_objects",
+		Help: "Number of replicated objects in the last full reconciliation",
+	}, []string{"kind"})
+	loopDurationSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "resource_replicator_loop_duration_seconds",
+		Help:    "Duration of the full reconciliations",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 12),
+	})
+	apiRequestDurationSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "resource_replicator_api_request_duration_seconds",
+		Help:    "Latency of the create, update, delete and patch requests to the api server",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"kind", "verb"})
+	lastSuccessfulReconcileTimestamp = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "resource_replicator_last_successful_reconcile_timestamp_seconds",
+		Help: "Unix time of the last full reconciliation without errors",
+	})
+)
+
+// records the actions and errors of processResources, the actions are not recorded in dry-run mode as they did not happen
+func recordResult(kind string, result *replicationResult) {
+	if !configDryRun {
+		replicationActionsTotal.WithLabelValues(kind, ACTION_CREATE).Add(float64(result.created))
+		replicationActionsTotal.WithLabelValues(kind, ACTION_UPDATE).Add(float64(result.updated))
+		replicationActionsTotal.WithLabelValues(kind, ACTION_DELETE).Add(float64(result.deleted))
+	}
+	replicationErrorsTotal.WithLabelValues(kind).Add(float64(len(result.errors)))
+}
+
+// resourceAdapter that measures the latency of the api requests of the wrapped adapter
+type instrumentedAdapter struct {
+	resourceAdapter
+}
+
+func newInstrumentedAdapter(adapter resourceAdapter) *instrumentedAdapter {
+	return &instrumentedAdapter{resourceAdapter: adapter}
+}
+
+func (a *instrumentedAdapter) observe(verb string, start time.Time) {
+	apiRequestDurationSeconds.WithLabelValues(a.kind(), verb).Observe(time.Since(start).Seconds())
+}
+
+func (a *instrumentedAdapter) create(object kubeObject) error {
+	defer a.observe("create", time.Now())
+	return a.resourceAdapter.create(object)
+}
+
+func (a *instrumentedAdapter) update(object kubeObject) error {
+	defer a.observe("update", time.Now())
+	return a.resourceAdapter.update(object)
+}
+
+func (a *instrumentedAdapter) delete(object kubeObject) error {
+	defer a.observe("delete", time.Now())
+	return a.resourceAdapter.delete(object)
+}
+
+// the delete and create of the recreate go through the instrumented adapter to be measured as well
+func (a *instrumentedAdapter) recreate(replicatedObject kubeObject, copiedObject kubeObject) (string, error) {
+	return recreateObject(a, replicatedObject, copiedObject)
+}
+
+func (a *instrumentedAdapter) patchAnnotations(object kubeObject, annotations map[string]*string) error {
+	defer a.observe("patch", time.Now())
+	return a.resourceAdapter.patchAnnotations(object, annotations)
+}
+
+// Serves the prometheus metrics on /metrics of the address in the background
+func serveMetrics(address string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	go func() {
+		log.Infof("Serving metrics on %v", address)
+		if err := http.ListenAndServe(address, mux); err != nil {
+			log.WithError(err).Error("Error serving metrics")
+		}
+	}()
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestProcessResourcesMetrics(t *testing.T) {
+	allNamespaces := newTestNamespaces("default", "ns-1", "ns-2", "ns-3")
+	objects := []testObject{
+		{namespace: "default", name: "app", annotations: map[string]string{REPLICATE_REGEX: "ns-[12]"}, data: map[string]string{"key": "new"}},
+		{namespace: "ns-1", name: "app", annotations: map[string]string{REPLICATED_ANNOTATION: "default/app"}, data: map[string]string{"key": "old"}},
+		{namespace: "ns-3", name: "orphan", annotations: map[string]string{REPLICATED_ANNOTATION: "default/orphan"}},
+	}
+	a := testAdapters["configmap"](objects)
+	adapter := newInstrumentedAdapter(a.adapter)
+	kubeObjects := make([]kubeObject, 0, len(objects))
+	for _, object := range objects {
+		kubeObjects = append(kubeObjects, a.newObject(object))
+	}
+
+	// the counters are global, so the difference to the values before processing is compared
+	counters := map[string]func() float64{
+		ACTION_CREATE: func() float64 {
+			return testutil.ToFloat64(replicationActionsTotal.WithLabelValues(adapter.kind(), ACTION_CREATE))
+		},
+		ACTION_UPDATE: func() float64 {
+			return testutil.ToFloat64(replicationActionsTotal.WithLabelValues(adapter.kind(), ACTION_UPDATE))
+		},
+		ACTION_DELETE: func() float64 {
+			return testutil.ToFloat64(replicationActionsTotal.WithLabelValues(adapter.kind(), ACTION_DELETE))
+		},
+		"errors": func() float64 { return testutil.ToFloat64(replicationErrorsTotal.WithLabelValues(adapter.kind())) },
+	}
+	before := make(map[string]float64)
+	for name, counter := range counters {
+		before[name] = counter()
+	}
+	requestsBefore := observedRequests(t, adapter.kind())
+
+	if result := processResources(adapter, record.NewFakeRecorder(10), allNamespaces, kubeObjects); len(result.errors) > 0 {
+		t.Fatalf("unexpected replication errors: %v", result.errors)
+	}
+
+	for name, counter := range counters {
+		if delta := counter() - before[name]; delta != 1 && name != "errors" {
+			t.Errorf("expected %v to increase by 1, got %v", name, delta)
+		} else if delta != 0 && name == "errors" {
+			t.Errorf("expected no errors to be counted, got %v", delta)
+		}
+	}
+	// the create, update and delete of the replicas, and the patch of the replication status of the source
+	if requests := observedRequests(t, adapter.kind()) - requestsBefore; requests != 4 {
+		t.Errorf("expected the latency of 4 api requests to be observed, got %v", requests)
+	}
+}
+
+// returns the number of api requests of the kind observed by the latency histogram
+func observedRequests(t *testing.T, kind string) uint64 {
+	var count uint64
+	for _, verb := range []string{"create", "update", "delete", "patch"} {
+		metric := &dto.Metric{}
+		if err := apiRequestDurationSeconds.WithLabelValues(kind, verb).(prometheus.Histogram).Write(metric); err != nil {
+			t.Fatalf("unexpected error reading the histogram: %v", err)
+		}
+		count += metric.GetHistogram().GetSampleCount()
+	}
+	return count
+}
+
+func TestProcessResourcesMetricsDryRun(t *testing.T) {
+	defer func(dryRun bool) { configDryRun = dryRun }(configDryRun)
+	configDryRun = true
+
+	objects := []testObject{
+		{namespace: "default", name: "app", annotations: map[string]string{REPLICATE_REGEX: "ns-1"}, data: map[string]string{"key": "value"}},
+	}
+	a := testAdapters["secret"](objects)
+	adapter := newDryRunAdapter(newInstrumentedAdapter(a.adapter))
+	before := testutil.ToFloat64(replicationActionsTotal.WithLabelValues(adapter.kind(), ACTION_CREATE))
+
+	result := processResources(adapter, record.NewFakeRecorder(10), newTestNamespaces("default", "ns-1"), []kubeObject{a.newObject(objects[0])})
+	if len(result.errors) > 0 {
+		t.Fatalf("unexpected replication errors: %v", result.errors)
+	}
+	if result.created != 1 {
+		t.Errorf("expected the simulated create to be counted in the result, got %v", result)
+	}
+	if delta := testutil.ToFloat64(replicationActionsTotal.WithLabelValues(adapter.kind(), ACTION_CREATE)) - before; delta != 0 {
+		t.Errorf("expected the simulated create to not be counted in the metrics, got %v", delta)
+	}
+}
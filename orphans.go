@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// actions of the orphan-policy annotation
+const (
+	ORPHAN_POLICY_DELETE              string = "delete"
+	ORPHAN_POLICY_RETAIN              string = "retain"
+	ORPHAN_POLICY_DELETE_AFTER_PREFIX string = "delete-after="
+)
+
+// what happens to a replicated object once its source object is deleted or no longer replicates to it
+type orphanPolicy struct {
+	// ORPHAN_POLICY_DELETE or ORPHAN_POLICY_RETAIN
+	action string
+	// for delete-after=<duration>, the orphan is retained for this duration before it is deleted
+	deleteAfter time.Duration
+}
+
+// Returns for how long the object has been orphaned, from the time in its orphaned-since annotation.
+// Returns false if the object has no or an invalid orphaned-since annotation
+func getOrphanedFor(object kubeObject, now time.Time) (time.Duration, bool) {
+	value, ok := object.GetAnnotations()[ORPHANED_SINCE_ANNOTATION]
+	if !ok {
+		return 0, false
+	}
+	orphanedSince, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return 0, false
+	}
+	return now.Sub(orphanedSince), true
+}
+
+// Checks if the object has been orphaned for CONFIG_ORPHAN_GRACE_PERIOD, so that its orphan policy applies.
+// The grace period protects the replicas from a source object that is missing only briefly (e.g. deleted and recreated).
+// It starts when the object is first found to be orphaned, which is recorded in the orphaned-since annotation of the object
+// so that it survives restarts and one-shot runs. The annotation is removed when a source object replicates to the object again
+func orphanGracePeriodPassed(adapter resourceAdapter, object kubeObject, now time.Time) (bool, error) {
+	orphanedFor, ok := getOrphanedFor(object, now)
+	if ok {
+		if orphanedFor < configOrphanGracePeriod {
+			targetLog(adapter, object).Debugf("Orphaned replica is within the grace period, orphaned for %v", orphanedFor.Round(time.Second))
+			return false, nil
+		}
+		return true, nil
+	}
+	if configOrphanGracePeriod == 0 {
+		return true, nil
+	}
+	orphanedSince := now.UTC().Format(time.RFC3339)
+	targetLog(adapter, object).Debugf("Replica is orphaned, its orphan policy applies after the grace period of %v", configOrphanGracePeriod)
+	return false, adapter.patchAnnotations(object, map[string]*string{ORPHANED_SINCE_ANNOTATION: &orphanedSince})
+}
+
+// Parses an orphan policy in the delete | retain | delete-after=<duration> format
+func parseOrphanPolicy(value string) (orphanPolicy, error) {
+	value = strings.TrimSpace(value)
+	switch {
+	case value == ORPHAN_POLICY_DELETE:
+		return orphanPolicy{action: ORPHAN_POLICY_DELETE}, nil
+	case value == ORPHAN_POLICY_RETAIN:
+		return orphanPolicy{action: ORPHAN_POLICY_RETAIN}, nil
+	case strings.HasPrefix(value, ORPHAN_POLICY_DELETE_AFTER_PREFIX):
+		deleteAfter, err := time.ParseDuration(strings.TrimPrefix(value, ORPHAN_POLICY_DELETE_AFTER_PREFIX))
+		if err != nil || deleteAfter < 0 {
+			return orphanPolicy{}, fmt.Errorf("invalid duration in orphan policy %q", value)
+		}
+		if deleteAfter == 0 {
+			// a deleteAfter of 0 means retain, so a zero duration deletes the orphan right away
+			return orphanPolicy{action: ORPHAN_POLICY_DELETE}, nil
+		}
+		return orphanPolicy{action: ORPHAN_POLICY_RETAIN, deleteAfter: deleteAfter}, nil
+	}
+	return orphanPolicy{}, fmt.Errorf("invalid orphan policy %q, expected %v, %v or %v<duration>", value, ORPHAN_POLICY_DELETE, ORPHAN_POLICY_RETAIN, ORPHAN_POLICY_DELETE_AFTER_PREFIX)
+}
+
+// Returns the orphan policy of a replicated object, copied from the orphan-policy annotation of its source object,
+// or the default policy in CONFIG_ORPHAN_POLICY
+func getOrphanPolicy(object kubeObject) (orphanPolicy, error) {
+	if value, ok := object.GetAnnotations()[ORPHAN_POLICY]; ok {
+		return parseOrphanPolicy(value)
+	}
+	return parseOrphanPolicy(configOrphanPolicy)
+}
+
+// Deletes or retains an orphaned object according to its orphan policy.
+// Retained orphans are marked with the orphaned-at annotation, orphans with delete-after=<duration> are deleted once the duration since then has passed.
+// The annotation is removed when a source object replicates to the orphan again
+func handleOrphanedObject(adapter resourceAdapter, object kubeObject) (string, error) {
+	policy, err := getOrphanPolicy(object)
+	if err != nil {
+		// keep the orphan, as it is unclear whether it should be deleted
+		return ACTION_NONE, err
+	}
+	if policy.action == ORPHAN_POLICY_DELETE {
+		return deleteOrphanedObject(adapter, object)
+	}
+
+	orphanedAtValue, ok := object.GetAnnotations()[ORPHANED_AT_ANNOTATION]
+	if !ok {
+		orphanedAt := time.Now().UTC().Format(time.RFC3339)
+		targetLog(adapter, object).Debug("Retaining orphaned replica")
+		if err := adapter.patchAnnotations(object, map[string]*string{ORPHANED_AT_ANNOTATION: &orphanedAt}); err != nil {
+			return ACTION_NONE, err
+		}
+		return ACTION_RETAIN, nil
+	}
+	if policy.deleteAfter == 0 {
+		return ACTION_NONE, nil
+	}
+	orphanedAt, err := time.Parse(time.RFC3339, orphanedAtValue)
+	if err != nil {
+		return ACTION_NONE, fmt.Errorf("invalid %v annotation %q: %w", ORPHANED_AT_ANNOTATION, orphanedAtValue, err)
+	}
+	if time.Since(orphanedAt) < policy.deleteAfter {
+		targetLog(adapter, object).Debugf("Retaining orphaned replica until %v", orphanedAt.Add(policy.deleteAfter))
+		return ACTION_NONE, nil
+	}
+	return deleteOrphanedObject(adapter, object)
+}
+
+func deleteOrphanedObject(adapter resourceAdapter, object kubeObject) (string, error) {
+	if err := deleteObject(adapter, object); err != nil {
+		return ACTION_NONE, err
+	}
+	return ACTION_DELETE, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/client-go/tools/record"
+)
+
+func TestHandleOrphanedObject(t *testing.T) {
+	expired := time.Now().Add(-2 * time.Hour).UTC().Format(time.RFC3339)
+	recent := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+
+	tests := []struct {
+		name          string
+		annotations   map[string]string
+		defaultPolicy string
+		expectDeleted bool
+		expectMarked  bool
+		expectError   bool
+	}{
+		{
+			name:          "deletes with the default policy",
+			defaultPolicy: ORPHAN_POLICY_DELETE,
+			expectDeleted: true,
+		},
+		{
+			name:          "retains with the default policy",
+			defaultPolicy: ORPHAN_POLICY_RETAIN,
+			expectMarked:  true,
+		},
+		{
+			name:          "orphan-policy annotation overrides the default policy",
+			annotations:   map[string]string{ORPHAN_POLICY: ORPHAN_POLICY_RETAIN},
+			defaultPolicy: ORPHAN_POLICY_DELETE,
+			expectMarked:  true,
+		},
+		{
+			name:          "marks orphans to delete after a duration",
+			annotations:   map[string]string{ORPHAN_POLICY: "delete-after=1h"},
+			defaultPolicy: ORPHAN_POLICY_DELETE,
+			expectMarked:  true,
+		},
+		{
+			name:          "retains orphans before the duration has passed",
+			annotations:   map[string]string{ORPHAN_POLICY: "delete-after=1h", ORPHANED_AT_ANNOTATION: recent},
+			defaultPolicy: ORPHAN_POLICY_DELETE,
+			expectMarked:  true,
+		},
+		{
+			name:          "deletes orphans after the duration has passed",
+			annotations:   map[string]string{ORPHAN_POLICY: "delete-after=1h", ORPHANED_AT_ANNOTATION: expired},
+			defaultPolicy: ORPHAN_POLICY_DELETE,
+			expectDeleted: true,
+		},
+		{
+			name:          "deletes orphans with a zero duration",
+			annotations:   map[string]string{ORPHAN_POLICY: "delete-after=0s"},
+			defaultPolicy: ORPHAN_POLICY_RETAIN,
+			expectDeleted: true,
+		},
+		{
+			name:          "keeps orphans with an invalid policy",
+			annotations:   map[string]string{ORPHAN_POLICY: "sometimes"},
+			defaultPolicy: ORPHAN_POLICY_DELETE,
+			expectError:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func(orphanPolicy string) { configOrphanPolicy = orphanPolicy }(configOrphanPolicy)
+			configOrphanPolicy = test.defaultPolicy
+
+			annotations := map[string]string{REPLICATED_ANNOTATION: "default/app"}
+			for k, v := range test.annotations {
+				annotations[k] = v
+			}
+			orphan := testObject{namespace: "ns-1", name: "app", annotations: annotations, data: map[string]string{"key": "value"}}
+			a := testAdapters["secret"]([]testObject{orphan})
+
+			_, err := handleOrphanedObject(a.adapter, a.newObject(orphan))
+			if (err != nil) != test.expectError {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			object, err := a.getObject(a.clientSet, "ns-1", "app")
+			if test.expectDeleted {
+				if err == nil {
+					t.Error("expected orphan to be deleted")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected orphan to exist: %v", err)
+			}
+			if _, marked := object.annotations[ORPHANED_AT_ANNOTATION]; marked != test.expectMarked {
+				t.Errorf("expected %v annotation to be set: %v, got %v", ORPHANED_AT_ANNOTATION, test.expectMarked, object.annotations)
+			}
+		})
+	}
+}
+
+func TestProcessResourcesOrphanGracePeriod(t *testing.T) {
+	defer func(gracePeriod time.Duration) { configOrphanGracePeriod = gracePeriod }(configOrphanGracePeriod)
+	configOrphanGracePeriod = time.Hour
+
+	orphan := testObject{namespace: "ns-1", name: "app", annotations: map[string]string{REPLICATED_ANNOTATION: "default/app"}, data: map[string]string{"key": "value"}}
+	a := testAdapters["secret"]([]testObject{orphan})
+	allNamespaces := newTestNamespaces("default", "ns-1")
+
+	// the orphan is marked and kept within the grace period
+	result := processResources(a.adapter, record.NewFakeRecorder(10), allNamespaces, []kubeObject{a.newObject(orphan)})
+	if len(result.errors) > 0 {
+		t.Fatalf("unexpected replication errors: %v", result.errors)
+	}
+	if result.pendingOrphans != 1 || result.deleted != 0 {
+		t.Errorf("expected the orphan to be counted as pending, got %v", result)
+	}
+	marked, err := a.getObject(a.clientSet, "ns-1", "app")
+	if err != nil {
+		t.Fatalf("expected orphan to be kept within the grace period: %v", err)
+	}
+	if _, ok := marked.annotations[ORPHANED_SINCE_ANNOTATION]; !ok {
+		t.Fatalf("expected orphan to be marked with the %v annotation, got %v", ORPHANED_SINCE_ANNOTATION, marked.annotations)
+	}
+
+	// a later run, e.g. after a restart, keeps the orphan until the grace period since the mark has passed
+	result = processResources(a.adapter, record.NewFakeRecorder(10), allNamespaces, []kubeObject{a.newObject(*marked)})
+	if result.pendingOrphans != 1 || result.deleted != 0 {
+		t.Errorf("expected the marked orphan to be counted as pending, got %v", result)
+	}
+
+	// and deletes it once it has been orphaned for the grace period
+	marked.annotations[ORPHANED_SINCE_ANNOTATION] = time.Now().Add(-2 * time.Hour).UTC().Format(time.RFC3339)
+	result = processResources(a.adapter, record.NewFakeRecorder(10), allNamespaces, []kubeObject{a.newObject(*marked)})
+	if len(result.errors) > 0 {
+		t.Fatalf("unexpected replication errors: %v", result.errors)
+	}
+	if result.pendingOrphans != 0 || result.deleted != 1 {
+		t.Errorf("expected the orphan to be counted as deleted, got %v", result)
+	}
+	if _, err := a.getObject(a.clientSet, "ns-1", "app"); err == nil {
+		t.Error("expected orphan to be deleted after the grace period")
+	}
+}
+
+func TestProcessResourcesOrphanedSinceRemoved(t *testing.T) {
+	objects := []testObject{
+		{namespace: "default", name: "app", annotations: map[string]string{REPLICATE_REGEX: "ns-1"}, data: map[string]string{"key": "value"}},
+		{namespace: "ns-1", name: "app", annotations: map[string]string{REPLICATED_ANNOTATION: "default/app", ORPHANED_SINCE_ANNOTATION: "2024-01-01T00:00:00Z"}, data: map[string]string{"key": "value"}},
+	}
+	a := testAdapters["secret"](objects)
+	if result := processResources(a.adapter, record.NewFakeRecorder(10), newTestNamespaces("default", "ns-1"), []kubeObject{a.newObject(objects[0]), a.newObject(objects[1])}); len(result.errors) > 0 {
+		t.Fatalf("unexpected replication errors: %v", result.errors)
+	}
+	replica, err := a.getObject(a.clientSet, "ns-1", "app")
+	if err != nil {
+		t.Fatalf("expected replica to exist: %v", err)
+	}
+	if _, ok := replica.annotations[ORPHANED_SINCE_ANNOTATION]; ok {
+		t.Errorf("expected the %v annotation to be removed once the source replicates to the replica again", ORPHANED_SINCE_ANNOTATION)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// Checks if given object is a source object that allows other namespaces to pull its data
+func isPullSourceObject(object metav1.Object) bool {
+	_, ok := object.GetAnnotations()[REPLICATION_ALLOWED_NAMESPACES]
+	return ok
+}
+
+// Returns the namespace and name of the source object in the replicate-from annotation of a pull target object.
+// Returns false if the object has no replicate-from annotation, or if it is not in the <namespace>/<name> format
+func getPullSource(object metav1.Object) (string, string, bool) {
+	annotation, ok := object.GetAnnotations()[REPLICATE_FROM]
+	if !ok {
+		return "", "", false
+	}
+	sourceNamespace, sourceName, ok := strings.Cut(annotation, "/")
+	if !ok || sourceNamespace == "" || sourceName == "" {
+		return "", "", false
+	}
+	return sourceNamespace, sourceName, true
+}
+
+// Last problem reported for every pull target object, keyed by kind, namespace and name,
+// so that the warnings and events are only emitted when the problem changes instead of on every reconciliation
+type pullProblemStore struct {
+	mu       sync.Mutex
+	problems map[string]string
+}
+
+var pullProblems = newPullProblemStore()
+
+func newPullProblemStore() *pullProblemStore {
+	return &pullProblemStore{problems: make(map[string]string)}
+}
+
+// records the problem of the key, returns false if it is the same problem as the one reported last
+func (s *pullProblemStore) report(key string, problem string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if reported, ok := s.problems[key]; ok && reported == problem {
+		return false
+	}
+	s.problems[key] = problem
+	return true
+}
+
+// removes the problem of the key once it is resolved
+func (s *pullProblemStore) resolve(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.problems, key)
+}
+
+// Get all objects with the replicate-from annotation
+func getPullTargetObjects(objects []kubeObject) []kubeObject {
+	targetObjects := make([]kubeObject, 0)
+	for _, object := range objects {
+		if _, ok := object.GetAnnotations()[REPLICATE_FROM]; ok {
+			targetObjects = append(targetObjects, object)
+		}
+	}
+	return targetObjects
+}
+
+// Checks if the replication-allowed-namespaces annotation of the source object allows the namespace to pull its data
+func isPullAllowed(sourceObject metav1.Object, namespace string) (bool, []string) {
+	annotation, ok := sourceObject.GetAnnotations()[REPLICATION_ALLOWED_NAMESPACES]
+	if !ok {
+		return false, nil
+	}
+	patterns, invalidPatterns := compileNamespacePatterns(annotation, !useSubstringMatch(sourceObject))
+	for _, pattern := range patterns {
+		if pattern.MatchString(namespace) {
+			return true, invalidPatterns
+		}
+	}
+	return false, invalidPatterns
+}
+
+// Fills in the data of a target object from the source object in its replicate-from annotation.
+// The source object is searched in objects, and it has to allow the target namespace in its replication-allowed-namespaces annotation.
+// Only the data is replicated, the labels and annotations of the target object are kept
+func pullObjectFromSource(adapter resourceAdapter, recorder record.EventRecorder, targetObject kubeObject, objects []kubeObject) (string, error) {
+	// the problems are reported when they change, later reconciliations only log them at debug level
+	problemKey := adapter.kind() + "/" + targetObject.GetNamespace() + "/" + targetObject.GetName()
+	sourceNamespace, sourceName, ok := getPullSource(targetObject)
+	if !ok {
+		message := fmt.Sprintf("Invalid %v annotation %q, expected <namespace>/<name>", REPLICATE_FROM, targetObject.GetAnnotations()[REPLICATE_FROM])
+		if pullProblems.report(problemKey, message) {
+			recorder.Event(targetObject, v1.EventTypeWarning, EVENT_REASON_INVALID_PATTERN, message)
+		}
+		return ACTION_NONE, nil
+	}
+	entry := pullLog(adapter, targetObject)
+
+	var sourceObject kubeObject
+	for _, object := range objects {
+		if object.GetNamespace() == sourceNamespace && object.GetName() == sourceName {
+			sourceObject = object
+			break
+		}
+	}
+	if sourceObject == nil {
+		if pullProblems.report(problemKey, "Source not found") {
+			entry.Warn("Source not found")
+		} else {
+			entry.Debug("Source not found")
+		}
+		return ACTION_NONE, nil
+	}
+
+	allowed, invalidPatterns := isPullAllowed(sourceObject, targetObject.GetNamespace())
+	patternsKey := problemKey + "/" + REPLICATION_ALLOWED_NAMESPACES
+	if len(invalidPatterns) > 0 {
+		value := strings.Join(invalidPatterns, ",")
+		if pullProblems.report(patternsKey, value) {
+			entry.Warnf("Skipping invalid patterns %q in %v annotation of the source", value, REPLICATION_ALLOWED_NAMESPACES)
+		}
+	} else {
+		pullProblems.resolve(patternsKey)
+	}
+	if !allowed {
+		message := fmt.Sprintf("%v/%v does not allow namespace %v in its %v annotation", sourceNamespace, sourceName, targetObject.GetNamespace(), REPLICATION_ALLOWED_NAMESPACES)
+		if !pullProblems.report(problemKey, message) {
+			entry.Debug("Source does not allow the target namespace to replicate from it")
+			return ACTION_NONE, nil
+		}
+		entry.Warn("Source does not allow the target namespace to replicate from it")
+		recorder.Event(targetObject, v1.EventTypeWarning, EVENT_REASON_REPLICATION_NOT_ALLOWED, message)
+		recorder.Event(sourceObject, v1.EventTypeWarning, EVENT_REASON_REPLICATION_NOT_ALLOWED, message)
+		return ACTION_NONE, nil
+	}
+	pullProblems.resolve(problemKey)
+
+	filteredObject, err := filterKeys(adapter, sourceObject)
+	if err != nil {
+		return ACTION_NONE, err
+	}
+	if adapter.equalPullData(filteredObject, targetObject) {
+		return ACTION_NONE, nil
+	}
+	updatedObject := targetObject.DeepCopyObject().(kubeObject)
+	adapter.copyPullData(updatedObject, filteredObject)
+	// the target object is not owned by the replicator, so it is never recreated
+	if adapter.requiresRecreate(targetObject, updatedObject) {
+		return ACTION_NONE, fmt.Errorf("%v/%v cannot pull the data of %v/%v as it is immutable or has another type", targetObject.GetNamespace(), targetObject.GetName(), sourceNamespace, sourceName)
+	}
+	entry.Debug("Pulling the data of the source")
+	if err := adapter.update(updatedObject); err != nil {
+		return ACTION_NONE, err
+	}
+	return ACTION_UPDATE, nil
+}
+
+// returns a log entry with the kind and the namespace and name of the pull target object and its source object
+func pullLog(adapter resourceAdapter, targetObject kubeObject) *log.Entry {
+	sourceNamespace, sourceName, _ := getPullSource(targetObject)
+	return targetLog(adapter, targetObject).WithFields(log.Fields{LOG_FIELD_SOURCE_NAMESPACE: sourceNamespace, LOG_FIELD_SOURCE_NAME: sourceName})
+}
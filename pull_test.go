@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestPullObjectFromSourceNotAllowedEvents(t *testing.T) {
+	defer func(problems *pullProblemStore) { pullProblems = problems }(pullProblems)
+	pullProblems = newPullProblemStore()
+
+	source := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app", Annotations: map[string]string{REPLICATION_ALLOWED_NAMESPACES: "ns-2"}}, Data: map[string][]byte{"key": []byte("value")}}
+	target := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "ns-1", Name: "creds", Annotations: map[string]string{REPLICATE_FROM: "default/app"}}}
+	clientSet := fake.NewSimpleClientset(source, target)
+	adapter := newSecretAdapter(clientSet, informers.NewSharedInformerFactory(clientSet, 0))
+	recorder := record.NewFakeRecorder(10)
+
+	// the events are emitted on the target and the source, only the first time the problem is found
+	for i := 0; i < 2; i++ {
+		if _, err := pullObjectFromSource(adapter, recorder, target, []kubeObject{source, target}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if len(recorder.Events) != 2 {
+		t.Errorf("expected 2 events for the first reconciliation only, got %d", len(recorder.Events))
+	}
+
+	// and again once the problem was resolved and comes back
+	source.Annotations[REPLICATION_ALLOWED_NAMESPACES] = "ns-1"
+	if _, err := pullObjectFromSource(adapter, recorder, target, []kubeObject{source, target}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	source.Annotations[REPLICATION_ALLOWED_NAMESPACES] = "ns-2"
+	if _, err := pullObjectFromSource(adapter, recorder, target, []kubeObject{source, target}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(recorder.Events) != 4 {
+		t.Errorf("expected the events to be emitted again after the problem was resolved, got %d events", len(recorder.Events))
+	}
+}
+
+func TestPullObjectFromSourceDataOnly(t *testing.T) {
+	immutable := true
+	sourceMeta := metav1.ObjectMeta{Namespace: "default", Name: "app", Annotations: map[string]string{REPLICATION_ALLOWED_NAMESPACES: "ns-1"}}
+	targetMeta := metav1.ObjectMeta{Namespace: "ns-1", Name: "creds", Annotations: map[string]string{REPLICATE_FROM: "default/app"}}
+
+	t.Run("secret", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			source *v1.Secret
+		}{
+			{
+				name:   "immutable source",
+				source: &v1.Secret{ObjectMeta: sourceMeta, Immutable: &immutable, Data: map[string][]byte{"key": []byte("new")}},
+			},
+			{
+				name:   "typed source",
+				source: &v1.Secret{ObjectMeta: sourceMeta, Type: v1.SecretTypeDockerConfigJson, Data: map[string][]byte{".dockerconfigjson": []byte("{}")}},
+			},
+		}
+
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				target := &v1.Secret{ObjectMeta: targetMeta, Type: v1.SecretTypeOpaque, Data: map[string][]byte{"key": []byte("old")}}
+				clientSet := fake.NewSimpleClientset(test.source, target)
+				adapter := newSecretAdapter(clientSet, informers.NewSharedInformerFactory(clientSet, 0))
+
+				// the data is pulled again after the source changes, the target is never made immutable
+				for _, value := range []string{"first", "second"} {
+					test.source.Data["key"] = []byte(value)
+					if _, err := pullObjectFromSource(adapter, record.NewFakeRecorder(10), target, []kubeObject{test.source, target}); err != nil {
+						t.Fatalf("unexpected error pulling %v: %v", value, err)
+					}
+					pulled, err := clientSet.CoreV1().Secrets("ns-1").Get(context.TODO(), "creds", metav1.GetOptions{})
+					if err != nil {
+						t.Fatalf("expected target to exist: %v", err)
+					}
+					if !cmp.Equal(pulled.Data, test.source.Data) {
+						t.Errorf("unexpected target data: %v", cmp.Diff(test.source.Data, pulled.Data))
+					}
+					if pulled.Type != v1.SecretTypeOpaque || pulled.Immutable != nil {
+						t.Errorf("expected the target to keep its type and immutable flag, got type %v immutable %v", pulled.Type, pulled.Immutable)
+					}
+					target = pulled
+				}
+			})
+		}
+	})
+
+	t.Run("configmap/immutable source", func(t *testing.T) {
+		source := &v1.ConfigMap{ObjectMeta: sourceMeta, Immutable: &immutable, Data: map[string]string{"key": "new"}, BinaryData: map[string][]byte{"bin": {0x01}}}
+		target := &v1.ConfigMap{ObjectMeta: targetMeta, Data: map[string]string{"key": "old"}}
+		clientSet := fake.NewSimpleClientset(source, target)
+		adapter := newConfigmapAdapter(clientSet, informers.NewSharedInformerFactory(clientSet, 0))
+
+		for _, value := range []string{"first", "second"} {
+			source.Data["key"] = value
+			if _, err := pullObjectFromSource(adapter, record.NewFakeRecorder(10), target, []kubeObject{source, target}); err != nil {
+				t.Fatalf("unexpected error pulling %v: %v", value, err)
+			}
+			pulled, err := clientSet.CoreV1().ConfigMaps("ns-1").Get(context.TODO(), "creds", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("expected target to exist: %v", err)
+			}
+			if !cmp.Equal(pulled.Data, source.Data) || !cmp.Equal(pulled.BinaryData, source.BinaryData) {
+				t.Errorf("unexpected target data: %v %v", pulled.Data, pulled.BinaryData)
+			}
+			if pulled.Immutable != nil {
+				t.Errorf("expected the target to keep its immutable flag, got %v", *pulled.Immutable)
+			}
+			target = pulled
+		}
+	})
+}
@@ -0,0 +1,632 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+// kubernetes object that can be replicated, implemented by the typed api objects (e.g. *v1.Secret) and *unstructured.Unstructured
+type kubeObject interface {
+	metav1.Object
+	runtime.Object
+}
+
+// resourceAdapter implements the kind specific operations of the replication pipeline.
+// Every kind of resource that can be replicated (e.g. secrets and configmaps) has its own implementation,
+// processResources then handles the replication and orphan deletion the same way for all kinds.
+type resourceAdapter interface {
+	// name of the resource kind, used in logs and workqueue keys
+	kind() string
+	// shared informer watching all objects of this kind
+	informer() cache.SharedIndexInformer
+	// list all objects of this kind from the informer cache
+	list() ([]kubeObject, error)
+	// get an object of this kind from the informer cache
+	get(namespace string, name string) (kubeObject, error)
+	create(object kubeObject) error
+	update(object kubeObject) error
+	delete(object kubeObject) error
+	// sets the annotations of an object with a merge patch, a nil value removes the annotation
+	patchAnnotations(object kubeObject, annotations map[string]*string) error
+	// checks if the replicated data of 2 objects is the same, labels and annotations are compared by checkObjectEquality
+	equalData(originalObject kubeObject, replicatedObject kubeObject) bool
+	// copies the replicated data of src into dst, used when updating an existing replicated object
+	copyData(dst kubeObject, src kubeObject)
+	// checks if the data of a pull target object is the same as the data of its source object,
+	// only the data is pulled, the target keeps its own type and immutable flag
+	equalPullData(sourceObject kubeObject, targetObject kubeObject) bool
+	// copies the data of the source object into the pull target object
+	copyPullData(dst kubeObject, src kubeObject)
+	// checks if the replicated object cannot be updated with the data of the copied object, and has to be deleted and recreated instead
+	// (e.g. the type of a secret changed, or the data of an immutable object changed)
+	requiresRecreate(replicatedObject kubeObject, copiedObject kubeObject) bool
+	// deletes the replicated object and creates the copied object instead, returns the action that was done
+	recreate(replicatedObject kubeObject, copiedObject kubeObject) (string, error)
+	// removes the keys of the data of an object for which keyFilter returns false
+	filterData(object kubeObject, keyFilter func(key string) bool)
+	// returns the replicated data of an object as strings to describe its changes in dry-run mode, and whether the values are sensitive
+	diffData(object kubeObject) (map[string]string, bool)
+}
+
+type SourceObject struct {
+	object           kubeObject
+	targetNamespaces []string
+	invalidPatterns  []string
+}
+
+type ReplicatedObject struct {
+	object          kubeObject
+	sourceNamespace string
+	sourceName      string
+}
+
+// error of replicating a source object to a target namespace, or of deleting its replica in the target namespace
+type replicationError struct {
+	kind            string
+	sourceNamespace string
+	sourceName      string
+	targetNamespace string
+	err             error
+}
+
+// the kind, source object and target namespace are logged as fields when the error is added to the replicationResult
+func (e replicationError) Error() string {
+	return e.err.Error()
+}
+
+func (e replicationError) Unwrap() error {
+	return e.err
+}
+
+// actions taken on a replicated or pull target object
+const (
+	ACTION_NONE     string = "none"
+	ACTION_CREATE   string = "create"
+	ACTION_UPDATE   string = "update"
+	ACTION_RECREATE string = "recreate"
+	ACTION_DELETE   string = "delete"
+	ACTION_RETAIN   string = "retain"
+)
+
+// result of processResources, collects the actions and errors of the goroutines it starts
+type replicationResult struct {
+	mu sync.Mutex
+	// number of source objects
+	sources int
+	// number of target namespaces of the source objects, and of pull target objects
+	targets int
+	// number of replicated objects
+	replicas int
+	created  int
+	updated  int
+	deleted  int
+	// number of orphaned replicas whose orphan policy did not apply yet as they are within the grace period
+	pendingOrphans int
+	errors         []replicationError
+}
+
+func (r *replicationResult) add(err replicationError) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	log.WithFields(log.Fields{
+		LOG_FIELD_KIND:             err.kind,
+		LOG_FIELD_SOURCE_NAMESPACE: err.sourceNamespace,
+		LOG_FIELD_SOURCE_NAME:      err.sourceName,
+		LOG_FIELD_TARGET_NAMESPACE: err.targetNamespace,
+	}).WithError(err.err).Error("Error replicating")
+	r.errors = append(r.errors, err)
+}
+
+// counts an action taken on a replicated or pull target object
+func (r *replicationResult) count(action string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch action {
+	case ACTION_CREATE:
+		r.created++
+	case ACTION_UPDATE, ACTION_RECREATE:
+		r.updated++
+	case ACTION_DELETE:
+		r.deleted++
+	}
+}
+
+// counts an orphaned replica that is within the grace period
+func (r *replicationResult) countPendingOrphan() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pendingOrphans++
+}
+
+func (r *replicationResult) String() string {
+	return fmt.Sprintf("sources=%d targets=%d created=%d updated=%d deleted=%d pending_orphans=%d errors=%d", r.sources, r.targets, r.created, r.updated, r.deleted, r.pendingOrphans, len(r.errors))
+}
+
+// adds the counts and errors of another result
+func (r *replicationResult) merge(other *replicationResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sources += other.sources
+	r.targets += other.targets
+	r.replicas += other.replicas
+	r.created += other.created
+	r.updated += other.updated
+	r.deleted += other.deleted
+	r.pendingOrphans += other.pendingOrphans
+	r.errors = append(r.errors, other.errors...)
+}
+
+// function that takes a list of objects of a kind and replicates the source objects in it to the relevant namespaces
+// also fills in the objects that replicate from a source object, and scans and deletes any orphaned objects in the list.
+// The lists are read from the informer caches, either for every object of the kind in the cluster or for a single source object and its replicas.
+// Failures do not stop the processing of other objects, they are returned for every source and target namespace pair
+// together with the number of created, updated and deleted objects
+func processResources(adapter resourceAdapter, recorder record.EventRecorder, allNamespaces *v1.NamespaceList, objects []kubeObject) *replicationResult {
+	var wg sync.WaitGroup
+	result := &replicationResult{}
+	sourceObjects, replicatedObjects := getSourceAndReplicatedObjects(adapter, objects, allNamespaces, result)
+	pullTargetObjects := getPullTargetObjects(objects)
+	log.WithField(LOG_FIELD_KIND, adapter.kind()).Debugf("There are %d source objects to process", len(sourceObjects))
+	result.sources = len(sourceObjects)
+	result.replicas = len(replicatedObjects)
+	result.targets = len(pullTargetObjects)
+	for _, sourceObject := range sourceObjects {
+		result.targets += len(sourceObject.targetNamespaces)
+	}
+
+	// Reporting invalid patterns on the source objects
+	for _, sourceObject := range sourceObjects {
+		if err := reportInvalidPatterns(adapter, recorder, sourceObject); err != nil {
+			result.add(replicationError{kind: adapter.kind(), sourceNamespace: sourceObject.object.GetNamespace(), sourceName: sourceObject.object.GetName(), err: err})
+		}
+	}
+
+	// Replicating source objects
+	statusCollectors := make(map[string]*statusCollector, len(sourceObjects))
+	for _, sourceObject := range sourceObjects {
+		collector := newStatusCollector()
+		statusCollectors[sourceObject.object.GetNamespace()+"/"+sourceObject.object.GetName()] = collector
+		// replicate to all relevant namespaces
+		for _, replicateNamespace := range sourceObject.targetNamespaces {
+			wg.Add(1)
+			go func(object kubeObject, namespace string) {
+				defer wg.Done()
+				start := time.Now()
+				action, err := replicateObjectToNamespace(adapter, recorder, allNamespaces, object, namespace, replicatedObjects)
+				logAction(sourceLog(adapter, object).WithField(LOG_FIELD_TARGET_NAMESPACE, namespace), action, start)
+				recordReplicationEvent(recorder, object, namespace, action, err)
+				collector.record(namespace, action, err)
+				result.count(action)
+				if err != nil {
+					result.add(replicationError{kind: adapter.kind(), sourceNamespace: object.GetNamespace(), sourceName: object.GetName(), targetNamespace: namespace, err: err})
+				}
+			}(sourceObject.object, replicateNamespace)
+		}
+		sourceLog(adapter, sourceObject.object).Debug("Started replicating to all target namespaces")
+	}
+
+	// Pulling data into objects with the replicate-from annotation
+	for _, targetObject := range pullTargetObjects {
+		wg.Add(1)
+		go func(targetObject kubeObject) {
+			defer wg.Done()
+			start := time.Now()
+			action, err := pullObjectFromSource(adapter, recorder, targetObject, objects)
+			logAction(pullLog(adapter, targetObject), action, start)
+			result.count(action)
+			if err != nil {
+				sourceNamespace, sourceName, _ := getPullSource(targetObject)
+				result.add(replicationError{kind: adapter.kind(), sourceNamespace: sourceNamespace, sourceName: sourceName, targetNamespace: targetObject.GetNamespace(), err: err})
+			}
+		}(targetObject)
+	}
+
+	// Deleting or retaining orphaned objects, once they have been orphaned for the grace period
+	for _, replicatedObject := range replicatedObjects {
+		// check if source object still exists or regex still valid
+		if _, err := getObjectInSourceObjects(replicatedObject, sourceObjects); !errors.IsNotFound(err) {
+			continue
+		}
+		wg.Add(1)
+		go func(replicatedObject ReplicatedObject) {
+			defer wg.Done()
+			start := time.Now()
+			if passed, err := orphanGracePeriodPassed(adapter, replicatedObject.object, start); !passed {
+				result.countPendingOrphan()
+				if err != nil {
+					result.add(replicationError{kind: adapter.kind(), sourceNamespace: replicatedObject.sourceNamespace, sourceName: replicatedObject.sourceName, targetNamespace: replicatedObject.object.GetNamespace(), err: err})
+				}
+				return
+			}
+			action, err := handleOrphanedObject(adapter, replicatedObject.object)
+			logAction(targetLog(adapter, replicatedObject.object), action, start)
+			// the event is emitted on the source object if it still exists, e.g. when it no longer replicates to the namespace
+			if sourceObject := findSourceObject(sourceObjects, replicatedObject.sourceNamespace, replicatedObject.sourceName); sourceObject != nil {
+				recordPruneEvent(recorder, sourceObject, replicatedObject.object, action, err)
+				statusCollectors[replicatedObject.sourceNamespace+"/"+replicatedObject.sourceName].record(replicatedObject.object.GetNamespace(), action, err)
+			}
+			result.count(action)
+			if err != nil {
+				result.add(replicationError{kind: adapter.kind(), sourceNamespace: replicatedObject.sourceNamespace, sourceName: replicatedObject.sourceName, targetNamespace: replicatedObject.object.GetNamespace(), err: err})
+			}
+		}(replicatedObject)
+	}
+	wg.Wait()
+
+	// Writing the replication status to the source objects, it would only describe the simulated changes in dry-run mode
+	if !configDryRun {
+		now := time.Now()
+		for _, sourceObject := range sourceObjects {
+			if err := writeReplicationStatus(adapter, sourceObject, statusCollectors[sourceObject.object.GetNamespace()+"/"+sourceObject.object.GetName()], now); err != nil {
+				result.add(replicationError{kind: adapter.kind(), sourceNamespace: sourceObject.object.GetNamespace(), sourceName: sourceObject.object.GetName(), err: err})
+			}
+		}
+	}
+	recordResult(adapter.kind(), result)
+	return result
+}
+
+// returns the source object with the namespace and name, or nil if there is none
+func findSourceObject(sourceObjects []SourceObject, namespace string, name string) kubeObject {
+	for _, sourceObject := range sourceObjects {
+		if sourceObject.object.GetNamespace() == namespace && sourceObject.object.GetName() == name {
+			return sourceObject.object
+		}
+	}
+	return nil
+}
+
+// Checks if given object is a source object by checking the annotations
+func isSourceObject(object metav1.Object) bool {
+	annotations := object.GetAnnotations()
+	_, hasRegex := annotations[REPLICATE_REGEX]
+	_, hasSelector := annotations[REPLICATE_SELECTOR]
+	_, hasAllNamespaces := annotations[REPLICATE_ALL_NAMESPACES]
+	return hasRegex || hasSelector || hasAllNamespaces
+}
+
+// Checks if given object is a replicated object by checking the annotations
+func isReplicatedObject(object metav1.Object) bool {
+	_, ok := object.GetAnnotations()[REPLICATED_ANNOTATION]
+	return ok
+}
+
+// Returns the namespace and name of the source object in the replicated-from annotation of a replicated object.
+// Replicas created by previous versions only have the source namespace in the annotation, their source has the same name as the replica
+func getReplicatedSource(object metav1.Object) (string, string) {
+	annotation := object.GetAnnotations()[REPLICATED_ANNOTATION]
+	if sourceNamespace, sourceName, ok := strings.Cut(annotation, "/"); ok {
+		return sourceNamespace, sourceName
+	}
+	return annotation, object.GetName()
+}
+
+// Checks if the source object wins the conflict with the other source object when both replicate to the same object.
+// The oldest source object wins, source objects created at the same time are ordered by namespace and name
+func winsConflict(object metav1.Object, otherObject metav1.Object) bool {
+	creationTimestamp, otherCreationTimestamp := object.GetCreationTimestamp(), otherObject.GetCreationTimestamp()
+	if !creationTimestamp.Equal(&otherCreationTimestamp) {
+		return creationTimestamp.Before(&otherCreationTimestamp)
+	}
+	return object.GetNamespace()+"/"+object.GetName() < otherObject.GetNamespace()+"/"+otherObject.GetName()
+}
+
+// fuction that takes in all objects of a kind and returns a list of SourceObjects and a list of ReplicatedObjects
+// Source objects whose target namespaces cannot be evaluated are skipped and added to the errors of result
+func getSourceAndReplicatedObjects(adapter resourceAdapter, objects []kubeObject, allNamespaces *v1.NamespaceList, result *replicationResult) ([]SourceObject, []ReplicatedObject) {
+	// initialize array for SourceObjects and ReplicatedObjects
+	sourceObjects := make([]SourceObject, 0, 10)
+	replicatedObjects := make([]ReplicatedObject, 0, 10)
+
+	for _, object := range objects {
+		if isSourceObject(object) {
+			// Filter for all source objects
+			targetNamespaces, invalidPatterns, err := getReplicateNamespaces(allNamespaces, object)
+			if err != nil {
+				result.add(replicationError{kind: adapter.kind(), sourceNamespace: object.GetNamespace(), sourceName: object.GetName(), err: err})
+				continue
+			}
+			sourceObjects = append(sourceObjects, SourceObject{object: object, targetNamespaces: targetNamespaces, invalidPatterns: invalidPatterns})
+		} else if isReplicatedObject(object) {
+			// Filter for all replicated objects
+			sourceNamespace, sourceName := getReplicatedSource(object)
+			replicatedObjects = append(replicatedObjects, ReplicatedObject{object: object, sourceNamespace: sourceNamespace, sourceName: sourceName})
+		}
+	}
+
+	return sourceObjects, replicatedObjects
+}
+
+// Records the invalid patterns and label selectors of a source object in the invalid-patterns annotation and emits a Warning event when they change.
+// The annotation is removed once all patterns are valid
+func reportInvalidPatterns(adapter resourceAdapter, recorder record.EventRecorder, sourceObject SourceObject) error {
+	object := sourceObject.object
+	currentValue, hasAnnotation := object.GetAnnotations()[INVALID_PATTERNS_ANNOTATION]
+	if len(sourceObject.invalidPatterns) == 0 {
+		if !hasAnnotation {
+			return nil
+		}
+		sourceLog(adapter, object).Infof("All patterns are valid, removing %v annotation", INVALID_PATTERNS_ANNOTATION)
+		return adapter.patchAnnotations(object, map[string]*string{INVALID_PATTERNS_ANNOTATION: nil})
+	}
+
+	value := strings.Join(sourceObject.invalidPatterns, ",")
+	if hasAnnotation && currentValue == value {
+		return nil
+	}
+	sourceLog(adapter, object).Warnf("Skipping invalid patterns %q", value)
+	recorder.Eventf(object, v1.EventTypeWarning, EVENT_REASON_INVALID_PATTERN, "Skipping invalid namespace patterns or label selectors: %v", value)
+	return adapter.patchAnnotations(object, map[string]*string{INVALID_PATTERNS_ANNOTATION: &value})
+}
+
+// Get object in array of SourceObjects, error if not found or the replicatedObject Namespace is no longer valid to be replicated into (i.e. regex changed in the source object),
+// or if the replicatedObject no longer has the target name of the source object (i.e. target-name changed in the source object).
+// Used to search for the source object given a replicated one.
+func getObjectInSourceObjects(replicatedObject ReplicatedObject, sourceObjects []SourceObject) (kubeObject, error) {
+	for _, sourceObject := range sourceObjects {
+		if replicatedObject.sourceName == sourceObject.object.GetName() &&
+			replicatedObject.sourceNamespace == sourceObject.object.GetNamespace() &&
+			arrayContains(sourceObject.targetNamespaces, replicatedObject.object.GetNamespace()) {
+			// replicas are kept if the target name cannot be evaluated, the error is reported when replicating
+			name, err := getTargetName(sourceObject.object, replicatedObject.object.GetNamespace())
+			if err != nil || name == replicatedObject.object.GetName() {
+				return sourceObject.object, nil
+			}
+		}
+	}
+	return nil, errors.NewNotFound(schema.GroupResource{}, "")
+}
+
+// Get object in array of replicatedObjects, error if not found.
+// Used to search for the replicated object given a source object, its target namespace and target name.
+func getObjectInReplicatedObjects(object kubeObject, replicatedObjects []ReplicatedObject, namespace string, name string) (kubeObject, error) {
+	for _, replicatedObject := range replicatedObjects {
+		if replicatedObject.object.GetName() == name &&
+			replicatedObject.sourceName == object.GetName() &&
+			replicatedObject.sourceNamespace == object.GetNamespace() &&
+			replicatedObject.object.GetNamespace() == namespace {
+			return replicatedObject.object, nil
+		}
+	}
+	return nil, errors.NewNotFound(schema.GroupResource{}, "")
+}
+
+// returns a copy of the source object that can be created in the target namespace with the target name
+func copyForTarget(object kubeObject, namespace string, name string) kubeObject {
+	copiedObject := object.DeepCopyObject().(kubeObject)
+	// Remove annotation
+	annotations := copyAnnotations(copiedObject.GetAnnotations())
+	for _, annotation := range sourceOnlyAnnotations {
+		delete(annotations, annotation)
+	}
+	// the last applied configuration of kubectl contains the unfiltered data of the source object
+	delete(annotations, LAST_APPLIED_CONFIGURATION)
+	// add replicated-from annotations identifying the source object
+	annotations[REPLICATED_ANNOTATION] = object.GetNamespace() + "/" + object.GetName()
+	annotations[REPLICATED_FROM_UID_ANNOTATION] = string(object.GetUID())
+	copiedObject.SetAnnotations(annotations)
+	copiedObject.SetNamespace(namespace)
+	copiedObject.SetName(name)
+	// strip server-managed fields, owner references as the owners do not exist in the target namespace,
+	// and finalizers as no controller handles them for the replicas
+	copiedObject.SetUID("")
+	copiedObject.SetResourceVersion("")
+	copiedObject.SetGeneration(0)
+	copiedObject.SetCreationTimestamp(metav1.Time{})
+	copiedObject.SetManagedFields(nil)
+	copiedObject.SetOwnerReferences(nil)
+	copiedObject.SetFinalizers(nil)
+	if unstructuredObject, ok := copiedObject.(*unstructured.Unstructured); ok {
+		unstructured.RemoveNestedField(unstructuredObject.Object, "status")
+	}
+	return copiedObject
+}
+
+// Replicate source object to target namespace, with the name in its target-name annotation
+// Creates the replicated object if it does not exist, and update it if it exists and is not the same
+// Namespaces that are being terminated are skipped, as nothing can be created in them
+func replicateObjectToNamespace(adapter resourceAdapter, recorder record.EventRecorder, allNamespaces *v1.NamespaceList, object kubeObject, namespace string, replicatedObjects []ReplicatedObject) (string, error) {
+	name, err := getTargetName(object, namespace)
+	if err != nil {
+		return ACTION_NONE, err
+	}
+	// do nothing if the replicated object would be the source object itself
+	if namespace == object.GetNamespace() && name == object.GetName() {
+		return ACTION_NONE, nil
+	}
+	filteredObject, err := filterKeys(adapter, object)
+	if err != nil {
+		return ACTION_NONE, err
+	}
+	copiedObject := copyForTarget(filteredObject, namespace, name)
+
+	existingObject, err := getObjectInReplicatedObjects(object, replicatedObjects, namespace, name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// Check if an object that is not a replica of the source already exists with the same name
+			conflictingObject, err := adapter.get(namespace, name)
+			if err == nil {
+				return handleConflictingObject(adapter, recorder, allNamespaces, object, conflictingObject, copiedObject)
+			}
+			if !errors.IsNotFound(err) {
+				return ACTION_NONE, err
+			}
+			// Create object if it does not exist
+			sourceLog(adapter, object).WithFields(log.Fields{LOG_FIELD_TARGET_NAMESPACE: namespace, LOG_FIELD_TARGET_NAME: name}).Debug("Creating replica")
+			err = adapter.create(copiedObject)
+			if errors.HasStatusCause(err, v1.NamespaceTerminatingCause) {
+				sourceLog(adapter, object).WithField(LOG_FIELD_TARGET_NAMESPACE, namespace).Info("Skipping target namespace as it is terminating")
+				recorder.Eventf(object, v1.EventTypeWarning, EVENT_REASON_NAMESPACE_TERMINATING, "Not replicating to namespace %v as it is terminating", namespace)
+				return ACTION_NONE, nil
+			}
+			// the informer cache has not seen the object yet, e.g. a replica created by the previous sync.
+			// Its add event queues the source object again, which then compares it like any other replica or conflict
+			if errors.IsAlreadyExists(err) {
+				sourceLog(adapter, object).WithFields(log.Fields{LOG_FIELD_TARGET_NAMESPACE: namespace, LOG_FIELD_TARGET_NAME: name}).Debug("Replica already exists but is not in the informer cache yet, skipping")
+				return ACTION_NONE, nil
+			}
+			if err != nil {
+				return ACTION_NONE, err
+			}
+			return ACTION_CREATE, nil
+		}
+		return ACTION_NONE, err
+	} else {
+		// Check if object value is the same if it exists
+		// and updates the object if it is changed
+		if !checkObjectEquality(adapter, copiedObject, existingObject) {
+			targetLog(adapter, existingObject).Debug("Updating replica")
+			action, err := updateReplica(adapter, existingObject, copiedObject)
+			// a source object that is deleted and re-created with the same name takes over the replicas of the deleted one
+			if previousUID := existingObject.GetAnnotations()[REPLICATED_FROM_UID_ANNOTATION]; err == nil && previousUID != "" && previousUID != string(object.GetUID()) {
+				targetLog(adapter, existingObject).Infof("Took over replica of the previous source object with UID %v, as the source object was re-created", previousUID)
+				recorder.Eventf(object, v1.EventTypeNormal, EVENT_REASON_SOURCE_RECREATED, "Took over the replica in namespace %v from a deleted source object with the same name", namespace)
+			}
+			return action, err
+		}
+	}
+	return ACTION_NONE, nil
+}
+
+// updates an existing object in the target namespace with the annotations, labels and data of the copy of the source object.
+// Objects that cannot be updated are deleted and recreated from the copy, unless this is disabled with CONFIG_RECREATE_REPLICAS
+func updateReplica(adapter resourceAdapter, existingObject kubeObject, copiedObject kubeObject) (string, error) {
+	if adapter.requiresRecreate(existingObject, copiedObject) {
+		if !configRecreateReplicas {
+			return ACTION_NONE, fmt.Errorf("%v/%v cannot be updated as it is immutable or its type changed, and recreating replicas is disabled", existingObject.GetNamespace(), existingObject.GetName())
+		}
+		targetLog(adapter, copiedObject).Info("Recreating replica as it cannot be updated")
+		return adapter.recreate(existingObject, copiedObject)
+	}
+	updatedObject := existingObject.DeepCopyObject().(kubeObject)
+	updatedObject.SetAnnotations(copiedObject.GetAnnotations())
+	updatedObject.SetLabels(copiedObject.GetLabels())
+	adapter.copyData(updatedObject, copiedObject)
+	if err := adapter.update(updatedObject); err != nil {
+		return ACTION_NONE, err
+	}
+	return ACTION_UPDATE, nil
+}
+
+// handles an object in the target namespace that has the target name of the source object but is not one of its replicas.
+// If the object is a replica of another source object that still replicates to it, the source object that wins the conflict keeps it.
+// Orphaned replicas are taken over once they have been orphaned for the grace period, unless they are retained by their orphan policy.
+// Otherwise by default the object is left untouched and a warning event is emitted on both objects,
+// with the overwrite-existing annotation on the source the object is adopted and becomes a replica
+func handleConflictingObject(adapter resourceAdapter, recorder record.EventRecorder, allNamespaces *v1.NamespaceList, object kubeObject, conflictingObject kubeObject, copiedObject kubeObject) (string, error) {
+	namespace := conflictingObject.GetNamespace()
+	if isReplicatedObject(conflictingObject) {
+		otherNamespace, otherName := getReplicatedSource(conflictingObject)
+		otherObject, err := adapter.get(otherNamespace, otherName)
+		if err != nil && !errors.IsNotFound(err) {
+			return ACTION_NONE, err
+		}
+		claimed := err == nil && replicatesToObject(allNamespaces, otherObject, namespace, conflictingObject.GetName())
+		if claimed {
+			if !winsConflict(object, otherObject) {
+				sourceLog(adapter, object).WithField(LOG_FIELD_TARGET_NAMESPACE, namespace).Warnf("Skipping target namespace as its replica is claimed by %v/%v", otherNamespace, otherName)
+				recorder.Eventf(object, v1.EventTypeWarning, EVENT_REASON_CONFLICT, "Not replicating to namespace %v, %v/%v replicates to the same object and takes precedence", namespace, otherNamespace, otherName)
+				return ACTION_NONE, nil
+			}
+			recorder.Eventf(otherObject, v1.EventTypeWarning, EVENT_REASON_CONFLICT, "Replica in namespace %v is taken over by %v/%v, which replicates to the same object and takes precedence", namespace, object.GetNamespace(), object.GetName())
+		}
+		// orphans that are retained or within the grace period are left to their orphan policy, as their source may come back
+		if claimed || !isRetainedOrPendingOrphan(conflictingObject) {
+			// the replica is either orphaned or claimed by a source object that loses the conflict
+			targetLog(adapter, copiedObject).Infof("Taking over replica of %v/%v", otherNamespace, otherName)
+			return updateReplica(adapter, conflictingObject, copiedObject)
+		}
+	}
+
+	if useOverwriteExisting(object) {
+		targetLog(adapter, copiedObject).Info("Adopting existing object as replica")
+		return updateReplica(adapter, conflictingObject, copiedObject)
+	}
+	sourceLog(adapter, object).WithFields(log.Fields{LOG_FIELD_TARGET_NAMESPACE: namespace, LOG_FIELD_TARGET_NAME: conflictingObject.GetName()}).Warn("Skipping target namespace as it already has an object with the target name that is not replicated from the source")
+	recorder.Eventf(object, v1.EventTypeWarning, EVENT_REASON_CONFLICT, "Not replicating to namespace %v, an object with the same name that is not replicated from this object already exists", namespace)
+	recorder.Eventf(conflictingObject, v1.EventTypeWarning, EVENT_REASON_CONFLICT, "Not overwritten by the replica of %v/%v, this object is not replicated from it", object.GetNamespace(), object.GetName())
+	return ACTION_NONE, nil
+}
+
+// Checks if an orphaned replica is marked as retained by its orphan policy or is still within the orphan grace period
+func isRetainedOrPendingOrphan(object kubeObject) bool {
+	if _, ok := object.GetAnnotations()[ORPHANED_AT_ANNOTATION]; ok {
+		return true
+	}
+	orphanedFor, ok := getOrphanedFor(object, time.Now())
+	if !ok {
+		// the replica has not been marked as orphaned yet
+		return configOrphanGracePeriod > 0
+	}
+	return orphanedFor < configOrphanGracePeriod
+}
+
+// Checks if a source object replicates to the object with the name in the namespace
+func replicatesToObject(allNamespaces *v1.NamespaceList, object metav1.Object, namespace string, name string) bool {
+	if !isSourceObject(object) {
+		return false
+	}
+	targetNamespaces, _, err := getReplicateNamespaces(allNamespaces, object)
+	if err != nil || !arrayContains(targetNamespaces, namespace) {
+		return false
+	}
+	targetName, err := getTargetName(object, namespace)
+	return err == nil && targetName == name
+}
+
+// checks 2 objects if they are the same
+// this function checks the values, labels, and annotations
+func checkObjectEquality(adapter resourceAdapter, originalObject kubeObject, replicatedObject kubeObject) bool {
+	originalAnnotation := stripAllReplicatorAnnotations(originalObject.GetAnnotations())
+	replicatedAnnotation := stripAllReplicatorAnnotations(replicatedObject.GetAnnotations())
+
+	return adapter.equalData(originalObject, replicatedObject) &&
+		cmp.Equal(originalAnnotation, replicatedAnnotation) &&
+		originalObject.GetAnnotations()[REPLICATED_ANNOTATION] == replicatedObject.GetAnnotations()[REPLICATED_ANNOTATION] &&
+		originalObject.GetAnnotations()[REPLICATED_FROM_UID_ANNOTATION] == replicatedObject.GetAnnotations()[REPLICATED_FROM_UID_ANNOTATION] &&
+		cmp.Equal(originalObject.GetLabels(), replicatedObject.GetLabels())
+}
+
+// returns a merge patch that sets the given annotations, a nil value removes the annotation
+func annotationsMergePatch(annotations map[string]*string) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+}
+
+// deletes the replicated object and creates the copied object instead, shared by the recreate of the adapters.
+// If the create fails the replicated object is already deleted, it is created again by the next reconciliation
+func recreateObject(adapter resourceAdapter, replicatedObject kubeObject, copiedObject kubeObject) (string, error) {
+	if err := deleteObject(adapter, replicatedObject); err != nil {
+		return ACTION_NONE, err
+	}
+	if err := adapter.create(copiedObject); err != nil {
+		return ACTION_DELETE, err
+	}
+	return ACTION_RECREATE, nil
+}
+
+// deletes object, it is not an error if the object is already gone
+func deleteObject(adapter resourceAdapter, object kubeObject) error {
+	targetLog(adapter, object).Debug("Deleting object")
+	if err := adapter.delete(object); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
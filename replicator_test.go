@@ -0,0 +1,629 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	// orphans are handled right away, unless a test sets a grace period
+	configOrphanGracePeriod = 0
+	os.Exit(m.Run())
+}
+
+// object in a test case, created as a secret or a configmap depending on the adapter under test
+type testObject struct {
+	namespace   string
+	name        string
+	annotations map[string]string
+	data        map[string]string
+}
+
+func newTestNamespaces(names ...string) *v1.NamespaceList {
+	namespaces := &v1.NamespaceList{}
+	for _, name := range names {
+		namespaces.Items = append(namespaces.Items, v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}})
+	}
+	return namespaces
+}
+
+func newTestSecret(object testObject) *v1.Secret {
+	data := make(map[string][]byte)
+	for k, v := range object.data {
+		data[k] = []byte(v)
+	}
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: object.namespace, Name: object.name, Annotations: copyAnnotations(object.annotations)},
+		Data:       data,
+	}
+}
+
+func newTestConfigmap(object testObject) *v1.ConfigMap {
+	return &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: object.namespace, Name: object.name, Annotations: copyAnnotations(object.annotations)},
+		Data:       copyAnnotations(object.data),
+	}
+}
+
+// adapter under test, with helpers to seed the fake clientset and read the objects back as a testObject
+type testAdapter struct {
+	adapter   resourceAdapter
+	clientSet *fake.Clientset
+	newObject func(object testObject) kubeObject
+	getObject func(clientSet *fake.Clientset, namespace string, name string) (*testObject, error)
+}
+
+var testAdapters = map[string]func(objects []testObject) testAdapter{
+	"secret": func(objects []testObject) testAdapter {
+		runtimeObjects := make([]runtime.Object, 0, len(objects))
+		for _, object := range objects {
+			runtimeObjects = append(runtimeObjects, newTestSecret(object))
+		}
+		clientSet := fake.NewSimpleClientset(runtimeObjects...)
+		return testAdapter{
+			adapter:   seedInformer(newSecretAdapter(clientSet, informers.NewSharedInformerFactory(clientSet, 0)), runtimeObjects),
+			clientSet: clientSet,
+			newObject: func(object testObject) kubeObject { return newTestSecret(object) },
+			getObject: func(clientSet *fake.Clientset, namespace string, name string) (*testObject, error) {
+				secret, err := clientSet.CoreV1().Secrets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+				if err != nil {
+					return nil, err
+				}
+				data := make(map[string]string)
+				for k, v := range secret.Data {
+					data[k] = string(v)
+				}
+				return &testObject{namespace: namespace, name: name, annotations: secret.Annotations, data: data}, nil
+			},
+		}
+	},
+	"configmap": func(objects []testObject) testAdapter {
+		runtimeObjects := make([]runtime.Object, 0, len(objects))
+		for _, object := range objects {
+			runtimeObjects = append(runtimeObjects, newTestConfigmap(object))
+		}
+		clientSet := fake.NewSimpleClientset(runtimeObjects...)
+		return testAdapter{
+			adapter:   seedInformer(newConfigmapAdapter(clientSet, informers.NewSharedInformerFactory(clientSet, 0)), runtimeObjects),
+			clientSet: clientSet,
+			newObject: func(object testObject) kubeObject { return newTestConfigmap(object) },
+			getObject: func(clientSet *fake.Clientset, namespace string, name string) (*testObject, error) {
+				configmap, err := clientSet.CoreV1().ConfigMaps(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+				if err != nil {
+					return nil, err
+				}
+				return &testObject{namespace: namespace, name: name, annotations: configmap.Annotations, data: configmap.Data}, nil
+			},
+		}
+	},
+}
+
+// adds the seeded objects to the informer cache of the adapter, as the informers are not started in tests
+func seedInformer(adapter resourceAdapter, objects []runtime.Object) resourceAdapter {
+	for _, object := range objects {
+		adapter.informer().GetStore().Add(object)
+	}
+	return adapter
+}
+
+func TestProcessResources(t *testing.T) {
+	allNamespaces := newTestNamespaces("default", "ns-1", "ns-2", "other")
+
+	tests := []struct {
+		name    string
+		objects []testObject
+		// data of the objects that must exist after processing, keyed by namespace/name
+		expected map[string]map[string]string
+		// namespace/name of the objects that must not exist after processing
+		deleted []string
+		// annotations that must be set on the source object default/app after processing
+		sourceAnnotations map[string]string
+		// annotations that must not be set on the objects after processing, keyed by namespace/name
+		absentAnnotations map[string][]string
+		// value of CONFIG_ALLOW_OVERWRITE_EXISTING
+		allowOverwriteExisting bool
+	}{
+		{
+			name: "creates replicas in matching namespaces",
+			objects: []testObject{
+				{namespace: "default", name: "app", annotations: map[string]string{REPLICATE_REGEX: "ns-[0-9]"}, data: map[string]string{"key": "value"}},
+			},
+			expected: map[string]map[string]string{
+				"ns-1/app": {"key": "value"},
+				"ns-2/app": {"key": "value"},
+			},
+			deleted: []string{"other/app"},
+		},
+		{
+			name: "updates changed replicas",
+			objects: []testObject{
+				{namespace: "default", name: "app", annotations: map[string]string{REPLICATE_REGEX: "ns-1"}, data: map[string]string{"key": "new"}},
+				{namespace: "ns-1", name: "app", annotations: map[string]string{REPLICATED_ANNOTATION: "default"}, data: map[string]string{"key": "old"}},
+			},
+			expected: map[string]map[string]string{
+				"ns-1/app": {"key": "new"},
+			},
+		},
+		{
+			name: "deletes orphaned replicas",
+			objects: []testObject{
+				{namespace: "ns-1", name: "app", annotations: map[string]string{REPLICATED_ANNOTATION: "default/app"}, data: map[string]string{"key": "value"}},
+			},
+			deleted: []string{"ns-1/app"},
+		},
+		{
+			name: "updates retained orphans replicated to again",
+			objects: []testObject{
+				{namespace: "default", name: "app", annotations: map[string]string{REPLICATE_REGEX: "ns-1"}, data: map[string]string{"key": "value"}},
+				{namespace: "ns-1", name: "app", annotations: map[string]string{REPLICATED_ANNOTATION: "default/app", ORPHANED_AT_ANNOTATION: "2024-01-01T00:00:00Z"}, data: map[string]string{"key": "old"}},
+			},
+			expected: map[string]map[string]string{
+				"ns-1/app": {"key": "value"},
+			},
+		},
+		{
+			name: "deletes replicas after regex change",
+			objects: []testObject{
+				{namespace: "default", name: "app", annotations: map[string]string{REPLICATE_REGEX: "ns-1"}, data: map[string]string{"key": "value"}},
+				{namespace: "ns-1", name: "app", annotations: map[string]string{REPLICATED_ANNOTATION: "default/app"}, data: map[string]string{"key": "value"}},
+				{namespace: "ns-2", name: "app", annotations: map[string]string{REPLICATED_ANNOTATION: "default/app"}, data: map[string]string{"key": "value"}},
+			},
+			expected: map[string]map[string]string{
+				"ns-1/app": {"key": "value"},
+			},
+			deleted: []string{"ns-2/app"},
+		},
+		{
+			name: "replicates to all namespaces",
+			objects: []testObject{
+				{namespace: "default", name: "app", annotations: map[string]string{REPLICATE_ALL_NAMESPACES: "true"}, data: map[string]string{"key": "value"}},
+			},
+			expected: map[string]map[string]string{
+				"default/app": {"key": "value"},
+				"ns-1/app":    {"key": "value"},
+				"ns-2/app":    {"key": "value"},
+				"other/app":   {"key": "value"},
+			},
+		},
+		{
+			name: "keeps valid patterns when a pattern is invalid",
+			objects: []testObject{
+				{namespace: "default", name: "app", annotations: map[string]string{REPLICATE_REGEX: "ns-1,["}, data: map[string]string{"key": "value"}},
+			},
+			expected: map[string]map[string]string{
+				"ns-1/app": {"key": "value"},
+			},
+			deleted:           []string{"ns-2/app", "other/app"},
+			sourceAnnotations: map[string]string{INVALID_PATTERNS_ANNOTATION: "["},
+		},
+		{
+			name: "does not overwrite existing objects that are not replicas",
+			objects: []testObject{
+				{namespace: "default", name: "app", annotations: map[string]string{REPLICATE_REGEX: "ns-[0-9]"}, data: map[string]string{"key": "value"}},
+				{namespace: "ns-1", name: "app", data: map[string]string{"key": "theirs"}},
+			},
+			expected: map[string]map[string]string{
+				"ns-1/app": {"key": "theirs"},
+				"ns-2/app": {"key": "value"},
+			},
+		},
+		{
+			name: "does not overwrite replicas of another source that wins the conflict",
+			objects: []testObject{
+				{namespace: "default", name: "app", annotations: map[string]string{REPLICATE_REGEX: "ns-1"}, data: map[string]string{"key": "value"}},
+				{namespace: "other", name: "app", annotations: map[string]string{REPLICATE_REGEX: "ns-1", OVERWRITE_EXISTING: "true"}, data: map[string]string{"key": "other"}},
+				{namespace: "ns-1", name: "app", annotations: map[string]string{REPLICATED_ANNOTATION: "default/app"}, data: map[string]string{"key": "value"}},
+			},
+			expected: map[string]map[string]string{
+				"ns-1/app": {"key": "value"},
+			},
+		},
+		{
+			name: "takes over replicas of another source that loses the conflict",
+			objects: []testObject{
+				{namespace: "default", name: "app", annotations: map[string]string{REPLICATE_REGEX: "ns-1"}, data: map[string]string{"key": "value"}},
+				{namespace: "other", name: "app", annotations: map[string]string{REPLICATE_REGEX: "ns-1"}, data: map[string]string{"key": "other"}},
+				{namespace: "ns-1", name: "app", annotations: map[string]string{REPLICATED_ANNOTATION: "other/app"}, data: map[string]string{"key": "other"}},
+			},
+			expected: map[string]map[string]string{
+				"ns-1/app": {"key": "value"},
+			},
+		},
+		{
+			name: "does not take over retained orphans of another source",
+			objects: []testObject{
+				{namespace: "default", name: "app", annotations: map[string]string{REPLICATE_REGEX: "ns-1"}, data: map[string]string{"key": "value"}},
+				{namespace: "ns-1", name: "app", annotations: map[string]string{REPLICATED_ANNOTATION: "other/app", ORPHAN_POLICY: ORPHAN_POLICY_RETAIN, ORPHANED_AT_ANNOTATION: "2024-01-01T00:00:00Z"}, data: map[string]string{"key": "other"}},
+			},
+			expected: map[string]map[string]string{
+				"ns-1/app": {"key": "other"},
+			},
+		},
+		{
+			name: "adopts existing objects with overwrite-existing",
+			objects: []testObject{
+				{namespace: "default", name: "app", annotations: map[string]string{REPLICATE_REGEX: "ns-1", OVERWRITE_EXISTING: "true"}, data: map[string]string{"key": "value"}},
+				{namespace: "ns-1", name: "app", data: map[string]string{"key": "theirs"}},
+			},
+			expected: map[string]map[string]string{
+				"ns-1/app": {"key": "value"},
+			},
+			allowOverwriteExisting: true,
+		},
+		{
+			name: "does not adopt existing objects with overwrite-existing unless allowed",
+			objects: []testObject{
+				{namespace: "default", name: "app", annotations: map[string]string{REPLICATE_REGEX: "ns-1", OVERWRITE_EXISTING: "true"}, data: map[string]string{"key": "value"}},
+				{namespace: "ns-1", name: "app", data: map[string]string{"key": "theirs"}},
+			},
+			expected: map[string]map[string]string{
+				"ns-1/app": {"key": "theirs"},
+			},
+		},
+		{
+			name: "does not replicate the replicate-from annotation of a source that also pulls",
+			objects: []testObject{
+				{namespace: "other", name: "src", annotations: map[string]string{REPLICATION_ALLOWED_NAMESPACES: "default"}, data: map[string]string{"key": "value"}},
+				{namespace: "default", name: "app", annotations: map[string]string{REPLICATE_REGEX: "ns-1", REPLICATE_FROM: "other/src"}, data: map[string]string{"key": "value"}},
+			},
+			expected: map[string]map[string]string{
+				"ns-1/app": {"key": "value"},
+			},
+			absentAnnotations: map[string][]string{
+				"ns-1/app": {REPLICATE_FROM},
+			},
+		},
+		{
+			name: "replicates with a templated target name",
+			objects: []testObject{
+				{namespace: "default", name: "app", annotations: map[string]string{REPLICATE_REGEX: "ns-1", TARGET_NAME: "regcred-{{ .Namespace }}"}, data: map[string]string{"key": "value"}},
+			},
+			expected: map[string]map[string]string{
+				"ns-1/regcred-ns-1": {"key": "value"},
+			},
+			deleted: []string{"ns-1/app"},
+		},
+		{
+			name: "replicates to the source namespace with a different target name",
+			objects: []testObject{
+				{namespace: "default", name: "app", annotations: map[string]string{REPLICATE_REGEX: "default", TARGET_NAME: "regcred"}, data: map[string]string{"key": "value"}},
+			},
+			expected: map[string]map[string]string{
+				"default/app":     {"key": "value"},
+				"default/regcred": {"key": "value"},
+			},
+		},
+		{
+			name: "deletes renamed replicas after target name change",
+			objects: []testObject{
+				{namespace: "default", name: "app", annotations: map[string]string{REPLICATE_REGEX: "ns-1", TARGET_NAME: "regcred"}, data: map[string]string{"key": "value"}},
+				{namespace: "ns-1", name: "old-regcred", annotations: map[string]string{REPLICATED_ANNOTATION: "default/app"}, data: map[string]string{"key": "value"}},
+			},
+			expected: map[string]map[string]string{
+				"ns-1/regcred": {"key": "value"},
+			},
+			deleted: []string{"ns-1/old-regcred"},
+		},
+		{
+			name: "replicates only the filtered keys",
+			objects: []testObject{
+				{namespace: "default", name: "app", annotations: map[string]string{REPLICATE_REGEX: "ns-[0-9]", INCLUDE_KEYS: "ro-*"}, data: map[string]string{"ro-password": "ro", "admin-password": "admin"}},
+				{namespace: "ns-1", name: "app", annotations: map[string]string{REPLICATED_ANNOTATION: "default/app"}, data: map[string]string{"ro-password": "ro", "admin-password": "admin"}},
+			},
+			expected: map[string]map[string]string{
+				"ns-1/app": {"ro-password": "ro"},
+				"ns-2/app": {"ro-password": "ro"},
+			},
+		},
+		{
+			name: "does not replicate the last applied configuration with the unfiltered keys",
+			objects: []testObject{
+				{namespace: "default", name: "app", annotations: map[string]string{REPLICATE_REGEX: "ns-1", INCLUDE_KEYS: "ro-*", LAST_APPLIED_CONFIGURATION: `{"data":{"admin-password":"admin","ro-password":"ro"}}`}, data: map[string]string{"ro-password": "ro", "admin-password": "admin"}},
+			},
+			expected: map[string]map[string]string{
+				"ns-1/app": {"ro-password": "ro"},
+			},
+			absentAnnotations: map[string][]string{
+				"ns-1/app": {LAST_APPLIED_CONFIGURATION},
+			},
+		},
+		{
+			name: "pulls data from an allowed source",
+			objects: []testObject{
+				{namespace: "default", name: "app", annotations: map[string]string{REPLICATION_ALLOWED_NAMESPACES: "ns-[0-9]"}, data: map[string]string{"key": "value"}},
+				{namespace: "ns-1", name: "creds", annotations: map[string]string{REPLICATE_FROM: "default/app"}, data: map[string]string{"key": "old"}},
+			},
+			expected: map[string]map[string]string{
+				"ns-1/creds": {"key": "value"},
+			},
+			deleted: []string{"ns-1/app"},
+		},
+		{
+			name: "does not pull data from a source that does not allow the namespace",
+			objects: []testObject{
+				{namespace: "default", name: "app", annotations: map[string]string{REPLICATION_ALLOWED_NAMESPACES: "ns-2"}, data: map[string]string{"key": "value"}},
+				{namespace: "ns-1", name: "creds", annotations: map[string]string{REPLICATE_FROM: "default/app"}, data: map[string]string{"key": "old"}},
+			},
+			expected: map[string]map[string]string{
+				"ns-1/creds": {"key": "old"},
+			},
+		},
+		{
+			name: "does not pull data from a source without allowed namespaces",
+			objects: []testObject{
+				{namespace: "default", name: "app", annotations: map[string]string{REPLICATE_REGEX: "ns-2"}, data: map[string]string{"key": "value"}},
+				{namespace: "ns-1", name: "creds", annotations: map[string]string{REPLICATE_FROM: "default/app"}, data: map[string]string{"key": "old"}},
+			},
+			expected: map[string]map[string]string{
+				"ns-1/creds": {"key": "old"},
+				"ns-2/app":   {"key": "value"},
+			},
+		},
+	}
+
+	for kind, newTestAdapter := range testAdapters {
+		for _, test := range tests {
+			t.Run(kind+"/"+test.name, func(t *testing.T) {
+				defer func(allowOverwriteExisting bool) { configAllowOverwriteExisting = allowOverwriteExisting }(configAllowOverwriteExisting)
+				configAllowOverwriteExisting = test.allowOverwriteExisting
+				a := newTestAdapter(test.objects)
+				objects := make([]kubeObject, 0, len(test.objects))
+				for _, object := range test.objects {
+					objects = append(objects, a.newObject(object))
+				}
+
+				if result := processResources(a.adapter, record.NewFakeRecorder(10), allNamespaces, objects); len(result.errors) > 0 {
+					t.Fatalf("unexpected replication errors: %v", result.errors)
+				}
+
+				for key, data := range test.expected {
+					namespace, name := splitTestKey(key)
+					object, err := a.getObject(a.clientSet, namespace, name)
+					if err != nil {
+						t.Fatalf("expected %v to exist: %v", key, err)
+					}
+					if !cmp.Equal(object.data, data) {
+						t.Errorf("unexpected data in %v: %v", key, cmp.Diff(data, object.data))
+					}
+				}
+				for _, key := range test.deleted {
+					namespace, name := splitTestKey(key)
+					if _, err := a.getObject(a.clientSet, namespace, name); err == nil {
+						t.Errorf("expected %v to not exist", key)
+					}
+				}
+				for key, annotations := range test.absentAnnotations {
+					namespace, name := splitTestKey(key)
+					object, err := a.getObject(a.clientSet, namespace, name)
+					if err != nil {
+						t.Fatalf("expected %v to exist: %v", key, err)
+					}
+					for _, annotation := range annotations {
+						if value, ok := object.annotations[annotation]; ok {
+							t.Errorf("expected %v to not have annotation %v, got %q", key, annotation, value)
+						}
+					}
+				}
+				if test.sourceAnnotations != nil {
+					source, err := a.getObject(a.clientSet, "default", "app")
+					if err != nil {
+						t.Fatalf("expected source to exist: %v", err)
+					}
+					for k, v := range test.sourceAnnotations {
+						if source.annotations[k] != v {
+							t.Errorf("expected source annotation %v=%v, got %v", k, v, source.annotations[k])
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestProcessResourcesUnchangedReplica(t *testing.T) {
+	allNamespaces := newTestNamespaces("default", "ns-1")
+	for kind, newTestAdapter := range testAdapters {
+		t.Run(kind, func(t *testing.T) {
+			objects := []testObject{
+				{namespace: "default", name: "app", annotations: map[string]string{REPLICATE_REGEX: "ns-1"}, data: map[string]string{"key": "value"}},
+				{namespace: "ns-1", name: "app", annotations: map[string]string{REPLICATED_ANNOTATION: "default/app", REPLICATED_FROM_UID_ANNOTATION: ""}, data: map[string]string{"key": "value"}},
+			}
+			a := newTestAdapter(objects)
+			if result := processResources(a.adapter, record.NewFakeRecorder(10), allNamespaces, []kubeObject{a.newObject(objects[0]), a.newObject(objects[1])}); len(result.errors) > 0 {
+				t.Fatalf("unexpected replication errors: %v", result.errors)
+			}
+			if actions := withoutStatusPatches(a.clientSet.Actions()); len(actions) > 0 {
+				t.Errorf("expected no api calls for an unchanged replica, got %v", actions)
+			}
+		})
+	}
+}
+
+func TestProcessResourcesReplicaNotInCache(t *testing.T) {
+	objects := []testObject{
+		{namespace: "default", name: "app", annotations: map[string]string{REPLICATE_REGEX: "ns-1"}, data: map[string]string{"key": "value"}},
+	}
+	a := testAdapters["secret"](objects)
+	// the replica created by the first run is not added to the informer cache, as the informers are not started in tests
+	for i := 0; i < 2; i++ {
+		result := processResources(a.adapter, record.NewFakeRecorder(10), newTestNamespaces("default", "ns-1"), []kubeObject{a.newObject(objects[0])})
+		if len(result.errors) > 0 {
+			t.Fatalf("unexpected replication errors in run %d: %v", i+1, result.errors)
+		}
+	}
+	if _, err := a.getObject(a.clientSet, "ns-1", "app"); err != nil {
+		t.Fatalf("expected replica to exist: %v", err)
+	}
+}
+
+func TestProcessResourcesFilteredKeysUnchanged(t *testing.T) {
+	objects := []testObject{
+		{namespace: "default", name: "app", annotations: map[string]string{REPLICATE_REGEX: "ns-1", EXCLUDE_KEYS: "admin-*"}, data: map[string]string{"ro-password": "ro", "admin-password": "admin"}},
+		{namespace: "ns-1", name: "app", annotations: map[string]string{REPLICATED_ANNOTATION: "default/app", REPLICATED_FROM_UID_ANNOTATION: ""}, data: map[string]string{"ro-password": "ro"}},
+	}
+	for kind, newTestAdapter := range testAdapters {
+		t.Run(kind, func(t *testing.T) {
+			a := newTestAdapter(objects)
+			if result := processResources(a.adapter, record.NewFakeRecorder(10), newTestNamespaces("default", "ns-1"), []kubeObject{a.newObject(objects[0]), a.newObject(objects[1])}); len(result.errors) > 0 {
+				t.Fatalf("unexpected replication errors: %v", result.errors)
+			}
+			if actions := withoutStatusPatches(a.clientSet.Actions()); len(actions) > 0 {
+				t.Errorf("expected no api calls for a replica with the filtered keys, got %v", actions)
+			}
+		})
+	}
+}
+
+func TestProcessResourcesInvalidPatternEvent(t *testing.T) {
+	a := testAdapters["secret"]([]testObject{
+		{namespace: "default", name: "app", annotations: map[string]string{REPLICATE_REGEX: "(ns"}},
+	})
+	recorder := record.NewFakeRecorder(10)
+	source := a.newObject(testObject{namespace: "default", name: "app", annotations: map[string]string{REPLICATE_REGEX: "(ns"}})
+	processResources(a.adapter, recorder, newTestNamespaces("default"), []kubeObject{source})
+
+	select {
+	case event := <-recorder.Events:
+		if event != "Warning InvalidPattern Skipping invalid namespace patterns or label selectors: (ns" {
+			t.Errorf("unexpected event %q", event)
+		}
+	default:
+		t.Error("expected an InvalidPattern event")
+	}
+
+	// the event is only emitted when the invalid patterns change
+	source.SetAnnotations(map[string]string{REPLICATE_REGEX: "(ns", INVALID_PATTERNS_ANNOTATION: "(ns"})
+	processResources(a.adapter, recorder, newTestNamespaces("default"), []kubeObject{source})
+	select {
+	case event := <-recorder.Events:
+		t.Errorf("unexpected event %q", event)
+	default:
+	}
+}
+
+func TestProcessResourcesSourceIdentity(t *testing.T) {
+	objects := []testObject{
+		{namespace: "default", name: "app", annotations: map[string]string{REPLICATE_REGEX: "ns-1"}, data: map[string]string{"key": "value"}},
+		// replica created by a previous version, with only the source namespace in the annotation
+		{namespace: "ns-1", name: "app", annotations: map[string]string{REPLICATED_ANNOTATION: "default"}, data: map[string]string{"key": "value"}},
+	}
+	a := testAdapters["secret"](objects)
+	source := a.newObject(objects[0])
+	source.SetUID("source-uid")
+	if result := processResources(a.adapter, record.NewFakeRecorder(10), newTestNamespaces("default", "ns-1"), []kubeObject{source, a.newObject(objects[1])}); len(result.errors) > 0 {
+		t.Fatalf("unexpected replication errors: %v", result.errors)
+	}
+
+	replica, err := a.getObject(a.clientSet, "ns-1", "app")
+	if err != nil {
+		t.Fatalf("expected replica to exist: %v", err)
+	}
+	if replica.annotations[REPLICATED_ANNOTATION] != "default/app" {
+		t.Errorf("unexpected replicated-from annotation %v", replica.annotations[REPLICATED_ANNOTATION])
+	}
+	if replica.annotations[REPLICATED_FROM_UID_ANNOTATION] != "source-uid" {
+		t.Errorf("unexpected replicated-from-uid annotation %v", replica.annotations[REPLICATED_FROM_UID_ANNOTATION])
+	}
+}
+
+func TestProcessResourcesRecreatedSource(t *testing.T) {
+	objects := []testObject{
+		{namespace: "default", name: "app", annotations: map[string]string{REPLICATE_REGEX: "ns-1"}, data: map[string]string{"key": "value"}},
+		{namespace: "ns-1", name: "app", annotations: map[string]string{REPLICATED_ANNOTATION: "default/app", REPLICATED_FROM_UID_ANNOTATION: "deleted-uid"}, data: map[string]string{"key": "value"}},
+	}
+	a := testAdapters["secret"](objects)
+	source := a.newObject(objects[0])
+	source.SetUID("source-uid")
+	recorder := record.NewFakeRecorder(10)
+	if result := processResources(a.adapter, recorder, newTestNamespaces("default", "ns-1"), []kubeObject{source, a.newObject(objects[1])}); len(result.errors) > 0 {
+		t.Fatalf("unexpected replication errors: %v", result.errors)
+	}
+
+	replica, err := a.getObject(a.clientSet, "ns-1", "app")
+	if err != nil {
+		t.Fatalf("expected replica to exist: %v", err)
+	}
+	if replica.annotations[REPLICATED_FROM_UID_ANNOTATION] != "source-uid" {
+		t.Errorf("unexpected replicated-from-uid annotation %v", replica.annotations[REPLICATED_FROM_UID_ANNOTATION])
+	}
+	select {
+	case event := <-recorder.Events:
+		if event != "Normal SourceRecreated Took over the replica in namespace ns-1 from a deleted source object with the same name" {
+			t.Errorf("unexpected event %q", event)
+		}
+	default:
+		t.Error("expected a SourceRecreated event")
+	}
+}
+
+func TestWinsConflict(t *testing.T) {
+	older := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "z", Name: "app", CreationTimestamp: metav1.NewTime(time.Unix(100, 0))}}
+	newer := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "a", Name: "app", CreationTimestamp: metav1.NewTime(time.Unix(200, 0))}}
+	sameTime := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "b", Name: "app", CreationTimestamp: metav1.NewTime(time.Unix(200, 0))}}
+
+	if !winsConflict(older, newer) || winsConflict(newer, older) {
+		t.Error("expected the oldest source object to win the conflict")
+	}
+	if !winsConflict(newer, sameTime) || winsConflict(sameTime, newer) {
+		t.Error("expected the source object with the lowest namespace/name to win the conflict")
+	}
+}
+
+func TestProcessResourcesConflictEvents(t *testing.T) {
+	objects := []testObject{
+		{namespace: "default", name: "app", annotations: map[string]string{REPLICATE_REGEX: "ns-1"}, data: map[string]string{"key": "value"}},
+		{namespace: "ns-1", name: "app", data: map[string]string{"key": "theirs"}},
+	}
+	a := testAdapters["configmap"](objects)
+	recorder := record.NewFakeRecorder(10)
+	if result := processResources(a.adapter, recorder, newTestNamespaces("default", "ns-1"), []kubeObject{a.newObject(objects[0]), a.newObject(objects[1])}); len(result.errors) > 0 {
+		t.Fatalf("unexpected replication errors: %v", result.errors)
+	}
+
+	expected := []string{
+		"Warning Conflict Not replicating to namespace ns-1, an object with the same name that is not replicated from this object already exists",
+		"Warning Conflict Not overwritten by the replica of default/app, this object is not replicated from it",
+	}
+	for _, want := range expected {
+		select {
+		case event := <-recorder.Events:
+			if event != want {
+				t.Errorf("unexpected event %q, expected %q", event, want)
+			}
+		default:
+			t.Errorf("expected event %q", want)
+		}
+	}
+}
+
+// returns the api calls except for the patches of the replication status of source objects
+func withoutStatusPatches(actions []k8stesting.Action) []k8stesting.Action {
+	filtered := make([]k8stesting.Action, 0, len(actions))
+	for _, action := range actions {
+		if patch, ok := action.(k8stesting.PatchAction); ok && strings.Contains(string(patch.GetPatch()), REPLICATION_STATUS_ANNOTATION) {
+			continue
+		}
+		filtered = append(filtered, action)
+	}
+	return filtered
+}
+
+func splitTestKey(key string) (string, string) {
+	namespace, name, _ := strings.Cut(key, "/")
+	return namespace, name
+}
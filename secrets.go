@@ -2,196 +2,136 @@ package main
 
 import (
 	"context"
-	"sync"
 
 	"github.com/google/go-cmp/cmp"
-	log "github.com/sirupsen/logrus"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	v1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
 )
 
-type SourceSecret struct {
-	secret           v1.Secret
-	targetNamespaces []string
-}
-
-type ReplicatedSecret struct {
-	secret          v1.Secret
-	sourceNamespace string
-}
-
-// function to list all namespaces and source secrets and replicate them to the relevant namespaces
-// also scans and deletes any orphaned secrets.
-// It is optimized by only querying once for the list of source secrets, replicated secrets, and namespaces to be replicated to
-func processSecrets(clientSet *kubernetes.Clientset, allNamespaces *v1.NamespaceList, wg *sync.WaitGroup) {
-	defer wg.Done()
-	var secrets_wg sync.WaitGroup
-	// Get all secrets
-	allSecrets := getAllSecrets(clientSet)
-	sourceSecrets, replicatedSecrets := getSourceAndReplicatedSecrets(allSecrets, allNamespaces)
-	log.Debugf("There are %d secrets with the relevant annotations in the cluster", len(sourceSecrets))
-
-	// Replicating source secrets
-	for _, sourceSecret := range sourceSecrets {
-		// replicate to all relevant namespaces
-		for _, replicateNamespace := range sourceSecret.targetNamespaces {
-			secrets_wg.Add(1)
-			go replicateSecretToNamespace(clientSet, sourceSecret.secret, replicateNamespace, replicatedSecrets, &secrets_wg)
-		}
-		log.Debugf("Finished replicating all namespaces for secret %v", sourceSecret.secret.Name)
-	}
+// resourceAdapter implementation for secrets
+type secretAdapter struct {
+	clientSet      kubernetes.Interface
+	secretInformer cache.SharedIndexInformer
+	secretLister   corelisters.SecretLister
+}
 
-	// Deleting orphaned secrets
-	for _, replicatedSecret := range replicatedSecrets {
-		// check if source secret still exists or regex still valid
-		_, err := getSecretInSourceSecrets(replicatedSecret, sourceSecrets)
-		if err != nil {
-			if errors.IsNotFound(err) {
-				secrets_wg.Add(1)
-				go deleteSecret(clientSet, replicatedSecret.secret, &secrets_wg)
-			} else {
-				panic(err.Error())
-			}
-		}
+func newSecretAdapter(clientSet kubernetes.Interface, informerFactory informers.SharedInformerFactory) *secretAdapter {
+	secretInformer := informerFactory.Core().V1().Secrets()
+	return &secretAdapter{
+		clientSet:      clientSet,
+		secretInformer: secretInformer.Informer(),
+		secretLister:   secretInformer.Lister(),
 	}
-	secrets_wg.Wait()
+}
+
+func (a *secretAdapter) kind() string {
+	return "secret"
+}
+
+func (a *secretAdapter) informer() cache.SharedIndexInformer {
+	return a.secretInformer
 }
 
 // Get all secrets from all namespaces
-func getAllSecrets(clientSet *kubernetes.Clientset) *v1.SecretList {
-	allSecrets, err := clientSet.CoreV1().Secrets("").List(context.TODO(), metav1.ListOptions{})
+func (a *secretAdapter) list() ([]kubeObject, error) {
+	secrets, err := a.secretLister.List(labels.Everything())
 	if err != nil {
-		panic(err.Error())
+		return nil, err
+	}
+	objects := make([]kubeObject, 0, len(secrets))
+	for _, secret := range secrets {
+		objects = append(objects, secret)
 	}
-	return allSecrets
+	return objects, nil
 }
 
-// Checks if given secret is a source secret by checking the annotations
-func isSourceSecret(secret v1.Secret) bool {
-	return metav1.HasAnnotation(secret.ObjectMeta, REPLICATE_REGEX) || metav1.HasAnnotation(secret.ObjectMeta, REPLICATE_ALL_NAMESPACES)
+func (a *secretAdapter) get(namespace string, name string) (kubeObject, error) {
+	return a.secretLister.Secrets(namespace).Get(name)
 }
 
-// Checks if given secret is a replicated secret by checking the annotations
-func isReplicatedSecret(secret v1.Secret) bool {
-	return metav1.HasAnnotation(secret.ObjectMeta, REPLICATED_ANNOTATION)
+func (a *secretAdapter) create(object kubeObject) error {
+	secret := object.(*v1.Secret)
+	_, err := a.clientSet.CoreV1().Secrets(secret.Namespace).Create(context.TODO(), secret, metav1.CreateOptions{DryRun: getServerDryRun()})
+	return err
 }
 
-// fuction that takes in all secrets and returns a list of source Secrets and a list of replicatedSecrets
-func getSourceAndReplicatedSecrets(allSecrets *v1.SecretList, allNamespaces *v1.NamespaceList) ([]SourceSecret, []ReplicatedSecret) {
-	// initialize array for SourceSecrets and ReplicatedSecrets
-	sourceSecrets := make([]SourceSecret, 0, 10)
-	replicatedSecrets := make([]ReplicatedSecret, 0, 10)
+func (a *secretAdapter) update(object kubeObject) error {
+	secret := object.(*v1.Secret)
+	_, err := a.clientSet.CoreV1().Secrets(secret.Namespace).Update(context.TODO(), secret, metav1.UpdateOptions{DryRun: getServerDryRun()})
+	return err
+}
+
+func (a *secretAdapter) delete(object kubeObject) error {
+	return a.clientSet.CoreV1().Secrets(object.GetNamespace()).Delete(context.TODO(), object.GetName(), metav1.DeleteOptions{DryRun: getServerDryRun()})
+}
 
-	for _, secret := range allSecrets.Items {
-		if isSourceSecret(secret) {
-			// Filter for all source secrets
-			targetNamespaces, err := getReplicateNamespaces(allNamespaces, secret.ObjectMeta)
-			if err != nil {
-				panic(err.Error())
-			}
-			sourceSecrets = append(sourceSecrets, SourceSecret{secret: secret, targetNamespaces: targetNamespaces})
-		} else if isReplicatedSecret(secret) {
-			// Filter for all replicated secrets
-			replicatedSecrets = append(replicatedSecrets, ReplicatedSecret{secret: secret, sourceNamespace: secret.Annotations[REPLICATED_ANNOTATION]})
-		}
+func (a *secretAdapter) patchAnnotations(object kubeObject, annotations map[string]*string) error {
+	patch, err := annotationsMergePatch(annotations)
+	if err != nil {
+		return err
 	}
+	_, err = a.clientSet.CoreV1().Secrets(object.GetNamespace()).Patch(context.TODO(), object.GetName(), types.MergePatchType, patch, metav1.PatchOptions{DryRun: getServerDryRun()})
+	return err
+}
 
-	return sourceSecrets, replicatedSecrets
+// the type and the immutable flag of secrets are replicated together with the data
+func (a *secretAdapter) equalData(originalObject kubeObject, replicatedObject kubeObject) bool {
+	originalSecret, replicatedSecret := originalObject.(*v1.Secret), replicatedObject.(*v1.Secret)
+	return cmp.Equal(originalSecret.Data, replicatedSecret.Data, cmpopts.EquateEmpty()) &&
+		getSecretType(originalSecret) == getSecretType(replicatedSecret) &&
+		isImmutable(originalSecret.Immutable) == isImmutable(replicatedSecret.Immutable)
 }
 
-// Get secret in array of SourceSecrets, error if not found or the replicatedSecret Namespace is no longer valid to be replicated into (i.e. regex changed in the source secret).
-// Used to search for the source secret given a replicated one.
-func getSecretInSourceSecrets(replicatedSecret ReplicatedSecret, sourceSecrets []SourceSecret) (v1.Secret, error) {
-	for _, sourceSecret := range sourceSecrets {
-		if sourceSecret.secret.Name == replicatedSecret.secret.Name &&
-			replicatedSecret.sourceNamespace == sourceSecret.secret.Namespace &&
-			arrayContains(sourceSecret.targetNamespaces, replicatedSecret.secret.Namespace) {
-			return sourceSecret.secret, nil
-		}
-	}
-	return v1.Secret{}, errors.NewNotFound(schema.GroupResource{}, "")
-}
-
-// Get secret in array of replicatedSecrets, error if not found.
-// Used to search for the replicated secret given a sourceSecret.
-func getSecretInReplicatedSecrets(secret v1.Secret, replicatedSecrets []ReplicatedSecret, namespace string) (v1.Secret, error) {
-	for _, replicatedSecret := range replicatedSecrets {
-		if replicatedSecret.secret.Name == secret.Name &&
-			replicatedSecret.sourceNamespace == secret.Namespace &&
-			replicatedSecret.secret.Namespace == namespace {
-			return replicatedSecret.secret, nil
-		}
-	}
-	return v1.Secret{}, errors.NewNotFound(schema.GroupResource{}, "")
+// the type of a secret cannot be changed, and immutable secrets can only be deleted
+func (a *secretAdapter) requiresRecreate(replicatedObject kubeObject, copiedObject kubeObject) bool {
+	replicatedSecret, copiedSecret := replicatedObject.(*v1.Secret), copiedObject.(*v1.Secret)
+	return getSecretType(replicatedSecret) != getSecretType(copiedSecret) ||
+		(isImmutable(replicatedSecret.Immutable) && !a.equalData(replicatedSecret, copiedSecret))
 }
 
-// Replicate source secret to target namespace
-// Creates the replicate secret if it does not exist, and update it if it exists and is not the same
-func replicateSecretToNamespace(clientSet *kubernetes.Clientset, secret v1.Secret, namespace string, replicatedSecrets []ReplicatedSecret, wg *sync.WaitGroup) {
-	defer wg.Done()
-	// do nothing if the target namespace is the same as the source secret namespace
-	if namespace == secret.Namespace {
-		return
-	}
-	// Remove annotation
-	copied_secret := secret.DeepCopy()
-	delete(copied_secret.Annotations, REPLICATE_REGEX)
-	delete(copied_secret.Annotations, REPLICATE_ALL_NAMESPACES)
-	// add replicated-from annotation
-	copied_secret.Annotations[REPLICATED_ANNOTATION] = secret.Namespace
-	copied_secret.Namespace = namespace
-	copied_secret.ResourceVersion = ""
-
-	existing_secret, err := getSecretInReplicatedSecrets(secret, replicatedSecrets, namespace)
-	if err != nil {
-		if errors.IsNotFound(err) {
-			// Create secret if it does not exist
-			log.Infof("Replicating [resource=secret][ns=%v][name=%v] to %v namespace...", secret.Namespace, secret.Name, namespace)
-			_, err := clientSet.CoreV1().Secrets(namespace).Create(context.TODO(), copied_secret, metav1.CreateOptions{})
-			if err != nil {
-				panic(err.Error())
-			}
-		} else {
-			panic(err.Error())
-		}
-	} else {
-		// Check if secret value is the same if it exists
-		// and updates the secret if it is changed
-		if !checkSecretEquality(*copied_secret, existing_secret) {
-			// updates secret
-			updated_secret := existing_secret.DeepCopy()
-			updated_secret.Annotations = copied_secret.Annotations
-			updated_secret.Data = copied_secret.Data
-			updated_secret.Labels = copied_secret.Labels
-			log.Infof("Updating [resource=secret][ns=%v][name=%v] to %v namespace...", secret.Namespace, secret.Name, namespace)
-			_, err := clientSet.CoreV1().Secrets(namespace).Update(context.TODO(), updated_secret, metav1.UpdateOptions{})
-			if err != nil {
-				panic(err.Error())
-			}
-		}
-	}
+func (a *secretAdapter) recreate(replicatedObject kubeObject, copiedObject kubeObject) (string, error) {
+	return recreateObject(a, replicatedObject, copiedObject)
 }
 
-// checks 2 secrets if they are the same
-// this function checks the values, labels, and annotations
-func checkSecretEquality(originalSecret v1.Secret, replicatedSecret v1.Secret) bool {
-	originalAnnotation := stripAllReplicatorAnnotations(originalSecret.Annotations)
-	replicatedAnnotation := stripAllReplicatorAnnotations(replicatedSecret.Annotations)
+func (a *secretAdapter) filterData(object kubeObject, keyFilter func(key string) bool) {
+	filterMap(object.(*v1.Secret).Data, keyFilter)
+	filterMap(object.(*v1.Secret).StringData, keyFilter)
+}
 
-	return cmp.Equal(originalSecret.Data, replicatedSecret.Data) &&
-		cmp.Equal(originalAnnotation, replicatedAnnotation) &&
-		cmp.Equal(originalSecret.Labels, replicatedSecret.Labels)
+func (a *secretAdapter) copyData(dst kubeObject, src kubeObject) {
+	dst.(*v1.Secret).Data = src.(*v1.Secret).Data
+	dst.(*v1.Secret).Type = src.(*v1.Secret).Type
+	dst.(*v1.Secret).Immutable = src.(*v1.Secret).Immutable
 }
 
-// deletes secret
-func deleteSecret(clientSet *kubernetes.Clientset, secret v1.Secret, wg *sync.WaitGroup) {
-	defer wg.Done()
-	log.Infof("Deleting secret %v in namespace %v...", secret.Name, secret.Namespace)
-	err := clientSet.CoreV1().Secrets(secret.Namespace).Delete(context.TODO(), secret.Name, metav1.DeleteOptions{})
-	if err != nil {
-		panic(err.Error())
+func (a *secretAdapter) equalPullData(sourceObject kubeObject, targetObject kubeObject) bool {
+	return cmp.Equal(sourceObject.(*v1.Secret).Data, targetObject.(*v1.Secret).Data, cmpopts.EquateEmpty())
+}
+
+func (a *secretAdapter) copyPullData(dst kubeObject, src kubeObject) {
+	dst.(*v1.Secret).Data = src.(*v1.Secret).Data
+}
+
+// all values of secrets are sensitive, a changed type is not described as the replica is recreated
+func (a *secretAdapter) diffData(object kubeObject) (map[string]string, bool) {
+	data := make(map[string]string)
+	for k, v := range object.(*v1.Secret).Data {
+		data["data."+k] = string(v)
+	}
+	return data, true
+}
+
+// secrets without a type are created as opaque secrets
+func getSecretType(secret *v1.Secret) v1.SecretType {
+	if secret.Type == "" {
+		return v1.SecretTypeOpaque
 	}
+	return secret.Type
 }
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestSecretAdapterRecreate(t *testing.T) {
+	immutable := true
+	tests := []struct {
+		name             string
+		source           *v1.Secret
+		replica          *v1.Secret
+		recreateReplicas bool
+		expectRecreate   bool
+		expectError      bool
+	}{
+		{
+			name:             "changed type",
+			source:           &v1.Secret{Type: v1.SecretTypeDockerConfigJson, Data: map[string][]byte{".dockerconfigjson": []byte("{}")}},
+			replica:          &v1.Secret{Type: v1.SecretTypeOpaque, Data: map[string][]byte{".dockerconfigjson": []byte("{}")}},
+			recreateReplicas: true,
+			expectRecreate:   true,
+		},
+		{
+			name:             "immutable replica with changed data",
+			source:           &v1.Secret{Immutable: &immutable, Data: map[string][]byte{"key": []byte("new")}},
+			replica:          &v1.Secret{Immutable: &immutable, Data: map[string][]byte{"key": []byte("old")}},
+			recreateReplicas: true,
+			expectRecreate:   true,
+		},
+		{
+			name:             "source made immutable",
+			source:           &v1.Secret{Immutable: &immutable, Data: map[string][]byte{"key": []byte("value")}},
+			replica:          &v1.Secret{Data: map[string][]byte{"key": []byte("value")}},
+			recreateReplicas: true,
+		},
+		{
+			name:             "recreating disabled",
+			source:           &v1.Secret{Type: v1.SecretTypeDockerConfigJson, Data: map[string][]byte{".dockerconfigjson": []byte("{}")}},
+			replica:          &v1.Secret{Type: v1.SecretTypeOpaque, Data: map[string][]byte{".dockerconfigjson": []byte("{}")}},
+			recreateReplicas: false,
+			expectError:      true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func(recreateReplicas bool) { configRecreateReplicas = recreateReplicas }(configRecreateReplicas)
+			configRecreateReplicas = test.recreateReplicas
+
+			test.source.ObjectMeta = metav1.ObjectMeta{Namespace: "default", Name: "app", Annotations: map[string]string{REPLICATE_REGEX: "ns-1"}}
+			test.replica.ObjectMeta = metav1.ObjectMeta{Namespace: "ns-1", Name: "app", Annotations: map[string]string{REPLICATED_ANNOTATION: "default/app"}}
+			clientSet := fake.NewSimpleClientset(test.source, test.replica)
+			adapter := newSecretAdapter(clientSet, informers.NewSharedInformerFactory(clientSet, 0))
+
+			errs := processResources(adapter, record.NewFakeRecorder(10), newTestNamespaces("default", "ns-1"), []kubeObject{test.source, test.replica}).errors
+			if (len(errs) > 0) != test.expectError {
+				t.Fatalf("unexpected replication errors: %v", errs)
+			}
+			verbs := make([]string, 0)
+			for _, action := range clientSet.Actions() {
+				verbs = append(verbs, action.GetVerb())
+			}
+			recreated := arrayContains(verbs, "delete") && arrayContains(verbs, "create")
+			if recreated != test.expectRecreate {
+				t.Errorf("expected recreate to be %v, got api calls %v", test.expectRecreate, verbs)
+			}
+			if test.expectError {
+				return
+			}
+
+			replica, err := clientSet.CoreV1().Secrets("ns-1").Get(context.TODO(), "app", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("expected replica to exist: %v", err)
+			}
+			if !adapter.equalData(test.source, replica) {
+				t.Errorf("expected replica to have the type, immutable flag and data of the source, got %v", replica)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// Replication state of a source object, written as json to its replication-status annotation
+type replicationStatus struct {
+	// resolved target namespaces of the source object
+	Targets []string `json:"targets"`
+	// time the replicas were last changed, or the status itself last changed. It is not refreshed by reconciliations
+	// that change nothing, so that an unchanged source object is not patched on every loop
+	LastChange string `json:"lastChange"`
+	// hash of the replicated data of the source object, after filtering its keys
+	Hash string `json:"hash"`
+	// error of the last replication to a target namespace, by namespace
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// collects the outcome of replicating a source object to its target namespaces while processing the resources
+type statusCollector struct {
+	mu      sync.Mutex
+	changed bool
+	errors  map[string]string
+}
+
+func newStatusCollector() *statusCollector {
+	return &statusCollector{errors: make(map[string]string)}
+}
+
+// records the outcome of replicating to or pruning a replica in the namespace
+func (c *statusCollector) record(namespace string, action string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		c.errors[namespace] = err.Error()
+	}
+	if action != ACTION_NONE && action != ACTION_RETAIN {
+		c.changed = true
+	}
+}
+
+// returns the hash of the replicated data of the object, the data is filtered by its include and exclude keys annotations
+func contentHash(adapter resourceAdapter, object kubeObject) (string, error) {
+	filteredObject, err := filterKeys(adapter, object)
+	if err != nil {
+		return "", err
+	}
+	data, _ := adapter.diffData(filteredObject)
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	hash := sha256.New()
+	for _, key := range keys {
+		// the pairs are json encoded so that different keys and values cannot result in the same input
+		json.NewEncoder(hash).Encode([]string{key, data[key]})
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Writes the replication status of the source object to its replication-status annotation.
+// The annotation is only patched when the targets, hash or errors change, or when replicas were changed,
+// so that an unchanged source object is not patched on every loop
+func writeReplicationStatus(adapter resourceAdapter, sourceObject SourceObject, collector *statusCollector, now time.Time) error {
+	object := sourceObject.object
+	hash, err := contentHash(adapter, object)
+	if err != nil {
+		return err
+	}
+	targets := append([]string{}, sourceObject.targetNamespaces...)
+	sort.Strings(targets)
+	collector.mu.Lock()
+	status := replicationStatus{Targets: targets, Hash: hash, Errors: collector.errors, LastChange: now.UTC().Format(time.RFC3339)}
+	changed := collector.changed
+	collector.mu.Unlock()
+
+	if !changed {
+		var currentStatus replicationStatus
+		if value, ok := object.GetAnnotations()[REPLICATION_STATUS_ANNOTATION]; ok && json.Unmarshal([]byte(value), &currentStatus) == nil {
+			currentStatus.LastChange = status.LastChange
+			if cmp.Equal(currentStatus, status, cmpopts.EquateEmpty()) {
+				return nil
+			}
+		}
+	}
+	value, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	sourceLog(adapter, object).Debugf("Updating %v annotation", REPLICATION_STATUS_ANNOTATION)
+	statusValue := string(value)
+	return adapter.patchAnnotations(object, map[string]*string{REPLICATION_STATUS_ANNOTATION: &statusValue})
+}
+
+// Checks if an update of an object only changed its replication-status annotation, i.e. it was written by the replicator,
+// such updates are not queued as they would reconcile the source object again
+func isStatusOnlyUpdate(oldObj interface{}, newObj interface{}) bool {
+	oldObject, ok := oldObj.(kubeObject)
+	if !ok {
+		return false
+	}
+	newObject, ok := newObj.(kubeObject)
+	if !ok {
+		return false
+	}
+	if oldObject.GetAnnotations()[REPLICATION_STATUS_ANNOTATION] == newObject.GetAnnotations()[REPLICATION_STATUS_ANNOTATION] {
+		return false
+	}
+	return equality.Semantic.DeepEqual(withoutStatus(oldObject), withoutStatus(newObject))
+}
+
+// returns a copy of the object without its replication-status annotation and the fields the api server changes on every update
+func withoutStatus(object kubeObject) runtime.Object {
+	copiedObject := object.DeepCopyObject().(kubeObject)
+	annotations := copyAnnotations(copiedObject.GetAnnotations())
+	delete(annotations, REPLICATION_STATUS_ANNOTATION)
+	copiedObject.SetAnnotations(annotations)
+	copiedObject.SetResourceVersion("")
+	copiedObject.SetManagedFields(nil)
+	return copiedObject
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestProcessResourcesReplicationStatus(t *testing.T) {
+	allNamespaces := newTestNamespaces("default", "ns-1", "ns-2")
+	objects := []testObject{
+		{namespace: "default", name: "app", annotations: map[string]string{REPLICATE_REGEX: "ns-[12]"}, data: map[string]string{"key": "value"}},
+	}
+	a := testAdapters["secret"](objects)
+	a.clientSet.PrependReactor("create", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetNamespace() == "ns-2" {
+			return true, nil, errors.NewForbidden(schema.GroupResource{Resource: "secrets"}, "app", fmt.Errorf("missing permission"))
+		}
+		return false, nil, nil
+	})
+	processResources(a.adapter, record.NewFakeRecorder(10), allNamespaces, []kubeObject{a.newObject(objects[0])})
+
+	source, err := a.clientSet.CoreV1().Secrets("default").Get(context.TODO(), "app", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected source to exist: %v", err)
+	}
+	var status replicationStatus
+	if err := json.Unmarshal([]byte(source.Annotations[REPLICATION_STATUS_ANNOTATION]), &status); err != nil {
+		t.Fatalf("invalid %v annotation %q: %v", REPLICATION_STATUS_ANNOTATION, source.Annotations[REPLICATION_STATUS_ANNOTATION], err)
+	}
+	hash, _ := contentHash(a.adapter, source)
+	expected := replicationStatus{
+		Targets:    []string{"ns-1", "ns-2"},
+		LastChange: status.LastChange,
+		Hash:       hash,
+		Errors:     map[string]string{"ns-2": `secrets "app" is forbidden: missing permission`},
+	}
+	if diff := cmp.Diff(expected, status); diff != "" {
+		t.Errorf("unexpected replication status (-want +got):\n%s", diff)
+	}
+	if _, err := time.Parse(time.RFC3339, status.LastChange); err != nil {
+		t.Errorf("invalid lastChange %q: %v", status.LastChange, err)
+	}
+
+	// the status is not patched again while it does not change
+	replica, err := a.clientSet.CoreV1().Secrets("ns-1").Get(context.TODO(), "app", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected replica to exist: %v", err)
+	}
+	a.clientSet.ClearActions()
+	processResources(a.adapter, record.NewFakeRecorder(10), allNamespaces, []kubeObject{source, replica})
+	for _, action := range a.clientSet.Actions() {
+		if action.GetVerb() == "patch" {
+			t.Errorf("unexpected patch of an unchanged replication status: %v", action)
+		}
+	}
+}
+
+func TestIsStatusOnlyUpdate(t *testing.T) {
+	oldObject := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app", ResourceVersion: "1", Annotations: map[string]string{REPLICATE_REGEX: "ns-1"}},
+		Data:       map[string][]byte{"key": []byte("value")},
+	}
+	tests := []struct {
+		name     string
+		update   func(secret *v1.Secret)
+		expected bool
+	}{
+		{
+			name: "status annotation written",
+			update: func(secret *v1.Secret) {
+				secret.Annotations[REPLICATION_STATUS_ANNOTATION] = `{"targets":["ns-1"]}`
+			},
+			expected: true,
+		},
+		{
+			name: "status annotation and data changed",
+			update: func(secret *v1.Secret) {
+				secret.Annotations[REPLICATION_STATUS_ANNOTATION] = `{"targets":["ns-1"]}`
+				secret.Data["key"] = []byte("new")
+			},
+			expected: false,
+		},
+		{
+			name: "replication annotation changed",
+			update: func(secret *v1.Secret) {
+				secret.Annotations[REPLICATE_REGEX] = "ns-2"
+			},
+			expected: false,
+		},
+		{
+			name:     "resync without changes",
+			update:   func(secret *v1.Secret) {},
+			expected: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			newObject := oldObject.DeepCopy()
+			newObject.ResourceVersion = "2"
+			test.update(newObject)
+			if got := isStatusOnlyUpdate(oldObject, newObject); got != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, got)
+			}
+		})
+	}
+}
@@ -1,17 +1,19 @@
 package main
 
 import (
-	"context"
 	"fmt"
 	"os"
 	"regexp"
 	"strconv"
 	"strings"
+	"text/template"
 	"time"
 
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/kubernetes"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
 func LookupEnvOrBool(key string, defaultValue bool) bool {
@@ -26,6 +28,14 @@ func LookupEnvOrBool(key string, defaultValue bool) bool {
 	return value
 }
 
+func LookupEnvOrString(key string, defaultValue string) string {
+	envVariable, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+	return envVariable
+}
+
 func LookupEnvOrDuration(key string, defaultValue time.Duration) time.Duration {
 	envVariable, exists := os.LookupEnv(key)
 	if !exists {
@@ -38,23 +48,48 @@ func LookupEnvOrDuration(key string, defaultValue time.Duration) time.Duration {
 	return value
 }
 
-func getAllNamespaces(clientSet *kubernetes.Clientset) *v1.NamespaceList {
-	namespaces, err := clientSet.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{})
+func LookupEnvOrInt(key string, defaultValue int) int {
+	envVariable, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+	value, err := strconv.Atoi(envVariable)
 	if err != nil {
-		panic(err.Error())
+		return defaultValue
 	}
-	return namespaces
+	return value
+}
+
+// Compile the comma separated patterns of a replicate-to or exclude-namespaces annotation, empty patterns are ignored.
+// Anchored patterns have to match the full namespace name, otherwise any substring of the name may match.
+// Returns the compiled regular expressions and the patterns that failed to compile.
+func compileNamespacePatterns(annotation string, anchored bool) ([]*regexp.Regexp, []string) {
+	compiledPatterns := make([]*regexp.Regexp, 0, 10)
+	invalidPatterns := make([]string, 0)
+	for _, pattern := range strings.Split(annotation, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		expression := pattern
+		if anchored {
+			expression = "^(?:" + pattern + ")$"
+		}
+		compiledPattern, err := regexp.Compile(expression)
+		if err != nil {
+			invalidPatterns = append(invalidPatterns, pattern)
+			continue
+		}
+		compiledPatterns = append(compiledPatterns, compiledPattern)
+	}
+	return compiledPatterns, invalidPatterns
 }
 
-func getAllRegexNamespaces(namespaces *v1.NamespaceList, pattern string) []v1.Namespace {
+func getAllRegexNamespaces(namespaces *v1.NamespaceList, pattern *regexp.Regexp) []v1.Namespace {
 	// match with regex
 	matchedNamespaces := make([]v1.Namespace, 0, 10)
 	for _, namespace := range namespaces.Items {
-		matched, err := regexp.MatchString(pattern, namespace.Name)
-		if err != nil {
-			panic(err.Error())
-		}
-		if matched {
+		if pattern.MatchString(namespace.Name) {
 			// log.Debugf("pattern=%v matched namespace=%v", pattern, namespace.Name)
 			matchedNamespaces = append(matchedNamespaces, namespace)
 		}
@@ -62,6 +97,17 @@ func getAllRegexNamespaces(namespaces *v1.NamespaceList, pattern string) []v1.Na
 	return matchedNamespaces
 }
 
+func getAllSelectorNamespaces(namespaces *v1.NamespaceList, selector labels.Selector) []v1.Namespace {
+	// match with label selector
+	matchedNamespaces := make([]v1.Namespace, 0, 10)
+	for _, namespace := range namespaces.Items {
+		if selector.Matches(labels.Set(namespace.Labels)) {
+			matchedNamespaces = append(matchedNamespaces, namespace)
+		}
+	}
+	return matchedNamespaces
+}
+
 func copyAnnotations(annotation map[string]string) map[string]string {
 	// copy a map
 	copiedAnnotation := make(map[string]string)
@@ -71,6 +117,14 @@ func copyAnnotations(annotation map[string]string) map[string]string {
 	return copiedAnnotation
 }
 
+// appends e to the array if it is not in the array yet
+func appendIfMissing[T comparable](s []T, e T) []T {
+	if arrayContains(s, e) {
+		return s
+	}
+	return append(s, e)
+}
+
 // fuction to check given string is in array or not
 func arrayContains[T comparable](s []T, e T) bool {
 	for _, v := range s {
@@ -81,37 +135,217 @@ func arrayContains[T comparable](s []T, e T) bool {
 	return false
 }
 
-// Evaluate the regex in annotation and return a list of all namespaces that configmap is needed to replicate to
-func getReplicateNamespaces(allNamespaces *v1.NamespaceList, obj metav1.ObjectMeta) ([]string, error) {
+// Checks if the patterns of the object match substrings of the namespace names (legacy behaviour) instead of the full names
+func useSubstringMatch(obj metav1.Object) bool {
+	if configSubstringMatch {
+		return true
+	}
+	substringMatch, err := strconv.ParseBool(obj.GetAnnotations()[SUBSTRING_MATCH])
+	return err == nil && substringMatch
+}
+
+// immutable flag of secrets and configmaps, nil is mutable
+func isImmutable(immutable *bool) bool {
+	return immutable != nil && *immutable
+}
+
+// Checks if the object is allowed to adopt existing objects with its name in the target namespaces.
+// The annotation is only honoured with CONFIG_ALLOW_OVERWRITE_EXISTING, as anyone able to annotate a source object
+// could otherwise overwrite objects in every namespace
+func useOverwriteExisting(obj metav1.Object) bool {
+	if !configAllowOverwriteExisting {
+		return false
+	}
+	overwriteExisting, err := strconv.ParseBool(obj.GetAnnotations()[OVERWRITE_EXISTING])
+	return err == nil && overwriteExisting
+}
+
+// values available in the target-name annotation template
+type targetNameTemplateData struct {
+	// name of the target namespace
+	Namespace string
+	// name of the source object
+	Name string
+}
+
+// Returns the name of the replicated object of the source object in the target namespace.
+// This is the name in the target-name annotation, evaluated as a text/template with the target namespace name (e.g. "regcred-{{ .Namespace }}"),
+// or the name of the source object if it has no target-name annotation
+func getTargetName(obj metav1.Object, namespace string) (string, error) {
+	annotation, ok := obj.GetAnnotations()[TARGET_NAME]
+	if !ok {
+		return obj.GetName(), nil
+	}
+	tmpl, err := template.New(TARGET_NAME).Option("missingkey=error").Parse(annotation)
+	if err != nil {
+		return "", fmt.Errorf("invalid %v annotation: %w", TARGET_NAME, err)
+	}
+	var name strings.Builder
+	if err := tmpl.Execute(&name, targetNameTemplateData{Namespace: namespace, Name: obj.GetName()}); err != nil {
+		return "", fmt.Errorf("invalid %v annotation: %w", TARGET_NAME, err)
+	}
+	if errs := validation.IsDNS1123Subdomain(name.String()); len(errs) > 0 {
+		return "", fmt.Errorf("invalid name %q in %v annotation: %v", name.String(), TARGET_NAME, strings.Join(errs, ", "))
+	}
+	return name.String(), nil
+}
+
+// Returns the namespaces the source object is no longer replicated to with anchored pattern matching, compared to substring matching.
+// Anchored patterns match a subset of the namespaces of substring matching, so no namespace is newly replicated to
+func substringMatchChanges(allNamespaces *v1.NamespaceList, obj metav1.Object) ([]string, error) {
+	anchoredNamespaces, _, err := getReplicateNamespacesWithMatching(allNamespaces, obj, false)
+	if err != nil {
+		return nil, err
+	}
+	substringNamespaces, _, err := getReplicateNamespacesWithMatching(allNamespaces, obj, true)
+	if err != nil {
+		return nil, err
+	}
+	removedNamespaces := make([]string, 0)
+	for _, namespace := range substringNamespaces {
+		if !arrayContains(anchoredNamespaces, namespace) {
+			removedNamespaces = append(removedNamespaces, namespace)
+		}
+	}
+	return removedNamespaces, nil
+}
+
+// Evaluate the regex and label selector in annotation and return a list of all namespaces that the object is needed to replicate to.
+// Namespaces matching either the regex or the label selector are replicated to.
+// Invalid patterns and selectors are skipped and returned as the second value, the valid ones are still evaluated
+func getReplicateNamespaces(allNamespaces *v1.NamespaceList, obj metav1.Object) ([]string, []string, error) {
+	return getReplicateNamespacesWithMatching(allNamespaces, obj, useSubstringMatch(obj))
+}
+
+// getReplicateNamespaces with the given pattern matching of the replicate-to patterns, used to compare the anchored and substring matching of an object.
+// The exclusions always use the pattern matching of the object
+func getReplicateNamespacesWithMatching(allNamespaces *v1.NamespaceList, obj metav1.Object, substringMatch bool) ([]string, []string, error) {
 	output := make([]string, 0, 10)
-	if metav1.HasAnnotation(obj, REPLICATE_REGEX) {
-		// evaluate the regex on the namespace
-		// append the names of the matched namespaces to output
-		patterns := strings.Split(obj.Annotations[REPLICATE_REGEX], ",")
-		for _, pattern := range patterns {
-			namespaces := getAllRegexNamespaces(allNamespaces, pattern)
-			for _, namespace := range namespaces {
-				output = append(output, namespace.Name)
+	invalidPatterns := make([]string, 0)
+	annotations := obj.GetAnnotations()
+	regexAnnotation, hasRegex := annotations[REPLICATE_REGEX]
+	selectorAnnotation, hasSelector := annotations[REPLICATE_SELECTOR]
+	if hasRegex || hasSelector {
+		if hasRegex {
+			// evaluate the regex on the namespace
+			// append the names of the matched namespaces to output
+			var patterns []*regexp.Regexp
+			patterns, invalidPatterns = compileNamespacePatterns(regexAnnotation, !substringMatch)
+			for _, pattern := range patterns {
+				namespaces := getAllRegexNamespaces(allNamespaces, pattern)
+				for _, namespace := range namespaces {
+					output = appendIfMissing(output, namespace.Name)
+				}
+			}
+		}
+		// an empty selector would match all namespaces, it matches none like an empty replicate-to
+		if hasSelector && strings.TrimSpace(selectorAnnotation) != "" {
+			// evaluate the label selector on the namespace labels
+			selector, err := labels.Parse(selectorAnnotation)
+			if err != nil {
+				invalidPatterns = append(invalidPatterns, selectorAnnotation)
+			} else {
+				for _, namespace := range getAllSelectorNamespaces(allNamespaces, selector) {
+					output = appendIfMissing(output, namespace.Name)
+				}
 			}
 		}
-	} else if metav1.HasAnnotation(obj, REPLICATE_ALL_NAMESPACES) {
+	} else if _, ok := annotations[REPLICATE_ALL_NAMESPACES]; ok {
 		// set output to all namespaces
 		for _, namespace := range allNamespaces.Items {
 			output = append(output, namespace.Name)
 		}
 	} else {
-		return output, fmt.Errorf("neither %v or %v annotation found in configmap [namespace=%v][name=%v]", REPLICATE_REGEX, REPLICATE_ALL_NAMESPACES, obj.Namespace, obj.Name)
+		return output, invalidPatterns, fmt.Errorf("none of %v, %v or %v annotation found", REPLICATE_REGEX, REPLICATE_SELECTOR, REPLICATE_ALL_NAMESPACES)
+	}
+
+	// subtract the excluded namespaces
+	excludedNamespaces, invalidExcludePatterns := getExcludedNamespaces(allNamespaces, obj)
+	invalidPatterns = append(invalidPatterns, invalidExcludePatterns...)
+	targetNamespaces := make([]string, 0, len(output))
+	for _, namespace := range output {
+		if !arrayContains(excludedNamespaces, namespace) {
+			targetNamespaces = append(targetNamespaces, namespace)
+		}
 	}
 
-	return output[:], nil
+	return targetNamespaces, invalidPatterns, nil
+}
+
+// Evaluate the controller-wide default exclusions and the exclude-namespaces annotations, and return a list of all namespaces that the object must not replicate to.
+// Invalid patterns and selectors are skipped and returned as the second value
+func getExcludedNamespaces(allNamespaces *v1.NamespaceList, obj metav1.Object) ([]string, []string) {
+	output := make([]string, 0, 10)
+	invalidPatterns := make([]string, 0)
+	annotations := obj.GetAnnotations()
+
+	// exclusion patterns match the full namespace names like the replicate-to patterns, unless substring matching is used.
+	// The default exclusions were validated at startup
+	anchored := !useSubstringMatch(obj)
+	patterns, _ := compileNamespacePatterns(configExcludeNamespaces, anchored)
+	if annotation, ok := annotations[EXCLUDE_REGEX]; ok {
+		var excludePatterns []*regexp.Regexp
+		excludePatterns, invalidPatterns = compileNamespacePatterns(annotation, anchored)
+		patterns = append(excludePatterns, patterns...)
+	}
+	for _, pattern := range patterns {
+		for _, namespace := range getAllRegexNamespaces(allNamespaces, pattern) {
+			output = appendIfMissing(output, namespace.Name)
+		}
+	}
+
+	// an empty selector would exclude all namespaces, it excludes none like an empty exclude-namespaces
+	if annotation, ok := annotations[EXCLUDE_SELECTOR]; ok && strings.TrimSpace(annotation) != "" {
+		selector, err := labels.Parse(annotation)
+		if err != nil {
+			invalidPatterns = append(invalidPatterns, annotation)
+		} else {
+			for _, namespace := range getAllSelectorNamespaces(allNamespaces, selector) {
+				output = appendIfMissing(output, namespace.Name)
+			}
+		}
+	}
+	return output, invalidPatterns
+}
+
+// Parse a comma separated list of resources in the <group>/<version>/<resource> format, or <version>/<resource> for the core group.
+// Secrets and configmaps are always replicated with their typed clients, so they cannot be listed again.
+func parseGroupVersionResources(resources string) ([]schema.GroupVersionResource, error) {
+	output := make([]schema.GroupVersionResource, 0, 10)
+	for _, resource := range strings.Split(resources, ",") {
+		resource = strings.TrimSpace(resource)
+		if resource == "" {
+			continue
+		}
+		var gvr schema.GroupVersionResource
+		parts := strings.Split(resource, "/")
+		switch len(parts) {
+		case 2:
+			gvr = schema.GroupVersionResource{Version: parts[0], Resource: parts[1]}
+		case 3:
+			gvr = schema.GroupVersionResource{Group: parts[0], Version: parts[1], Resource: parts[2]}
+		default:
+			return nil, fmt.Errorf("invalid resource %v, expected <group>/<version>/<resource> or <version>/<resource>", resource)
+		}
+		if gvr.Version == "" || gvr.Resource == "" {
+			return nil, fmt.Errorf("invalid resource %v, version and resource must not be empty", resource)
+		}
+		if gvr.Group == "" && (gvr.Resource == "secrets" || gvr.Resource == "configmaps") {
+			return nil, fmt.Errorf("invalid resource %v, secrets and configmaps are always replicated", resource)
+		}
+		output = append(output, gvr)
+	}
+	return output, nil
 }
 
 // remove all replicator annotations for resource comparison
 func stripAllReplicatorAnnotations(annotation map[string]string) map[string]string {
 	copied_annotation := copyAnnotations(annotation)
-	delete(copied_annotation, REPLICATE_REGEX)
+	for _, annotation := range sourceOnlyAnnotations {
+		delete(copied_annotation, annotation)
+	}
 	delete(copied_annotation, REPLICATED_ANNOTATION)
-	delete(copied_annotation, REPLICATE_ALL_NAMESPACES)
+	delete(copied_annotation, REPLICATED_FROM_UID_ANNOTATION)
 	delete(copied_annotation, LAST_APPLIED_CONFIGURATION)
 	return copied_annotation
 }
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestGetReplicateNamespaces(t *testing.T) {
+	allNamespaces := newTestNamespaces("default", "ns-1", "ns-2", "app-ns-10", "other")
+	allNamespaces.Items[1].Labels = map[string]string{"team": "payments", "env": "prod"}
+	allNamespaces.Items[2].Labels = map[string]string{"team": "payments", "env": "dev"}
+	allNamespaces.Items[4].Labels = map[string]string{"team": "search", "env": "prod"}
+
+	tests := []struct {
+		name            string
+		annotations     map[string]string
+		expected        []string
+		invalidPatterns []string
+		expectError     bool
+	}{
+		{
+			name:        "single name",
+			annotations: map[string]string{REPLICATE_REGEX: "other"},
+			expected:    []string{"other"},
+		},
+		{
+			name:        "comma separated names and patterns",
+			annotations: map[string]string{REPLICATE_REGEX: "default,app-ns-[0-9]*"},
+			expected:    []string{"default", "app-ns-10"},
+		},
+		{
+			name:        "all namespaces",
+			annotations: map[string]string{REPLICATE_ALL_NAMESPACES: "true"},
+			expected:    []string{"default", "ns-1", "ns-2", "app-ns-10", "other"},
+		},
+		{
+			name:            "invalid patterns are skipped",
+			annotations:     map[string]string{REPLICATE_REGEX: "other,ns-(,*"},
+			expected:        []string{"other"},
+			invalidPatterns: []string{"ns-(", "*"},
+		},
+		{
+			name:        "label selector",
+			annotations: map[string]string{REPLICATE_SELECTOR: "team=payments"},
+			expected:    []string{"ns-1", "ns-2"},
+		},
+		{
+			name:        "label selector with multiple requirements",
+			annotations: map[string]string{REPLICATE_SELECTOR: "team in (payments,search),env!=dev"},
+			expected:    []string{"ns-1", "other"},
+		},
+		{
+			name:        "union of regex and label selector without duplicates",
+			annotations: map[string]string{REPLICATE_REGEX: "default,ns-1", REPLICATE_SELECTOR: "env=prod"},
+			expected:    []string{"default", "ns-1", "other"},
+		},
+		{
+			name:            "invalid label selector",
+			annotations:     map[string]string{REPLICATE_REGEX: "default", REPLICATE_SELECTOR: "team in (payments"},
+			expected:        []string{"default"},
+			invalidPatterns: []string{"team in (payments"},
+		},
+		{
+			name:        "empty label selector matches no namespaces",
+			annotations: map[string]string{REPLICATE_SELECTOR: " "},
+			expected:    []string{},
+		},
+		{
+			name:        "excluded names and patterns",
+			annotations: map[string]string{REPLICATE_ALL_NAMESPACES: "true", EXCLUDE_REGEX: "default, app-ns-.*"},
+			expected:    []string{"ns-1", "ns-2", "other"},
+		},
+		{
+			name:        "empty excluded label selector excludes no namespaces",
+			annotations: map[string]string{REPLICATE_REGEX: "ns-[0-9]", EXCLUDE_SELECTOR: ""},
+			expected:    []string{"ns-1", "ns-2"},
+		},
+		{
+			name:        "excluded label selector",
+			annotations: map[string]string{REPLICATE_REGEX: "ns-[0-9],other", EXCLUDE_SELECTOR: "env=prod"},
+			expected:    []string{"ns-2"},
+		},
+		{
+			name:            "invalid exclude pattern",
+			annotations:     map[string]string{REPLICATE_REGEX: "ns-[0-9]", EXCLUDE_REGEX: "ns-2,ns-("},
+			expected:        []string{"ns-1"},
+			invalidPatterns: []string{"ns-("},
+		},
+		{
+			name:        "patterns match the full namespace name",
+			annotations: map[string]string{REPLICATE_REGEX: "ns-1,app"},
+			expected:    []string{"ns-1"},
+		},
+		{
+			name:        "exclusion patterns match the full namespace name",
+			annotations: map[string]string{REPLICATE_REGEX: "ns-[0-9]+,app-ns-[0-9]+", EXCLUDE_REGEX: "ns-1"},
+			expected:    []string{"ns-2", "app-ns-10"},
+		},
+		{
+			name:        "substring matching opt-in applies to exclusion patterns",
+			annotations: map[string]string{REPLICATE_REGEX: "ns-[0-9]+", EXCLUDE_REGEX: "ns-1", SUBSTRING_MATCH: "true"},
+			expected:    []string{"ns-2"},
+		},
+		{
+			name:        "substring matching opt-in",
+			annotations: map[string]string{REPLICATE_REGEX: "ns-1", SUBSTRING_MATCH: "true"},
+			expected:    []string{"ns-1", "app-ns-10"},
+		},
+		{
+			name:        "no replication annotation",
+			annotations: map[string]string{},
+			expected:    []string{},
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			object := &metav1.ObjectMeta{Namespace: "default", Name: "app", Annotations: test.annotations}
+			namespaces, invalidPatterns, err := getReplicateNamespaces(allNamespaces, object)
+			if (err != nil) != test.expectError {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !cmp.Equal(namespaces, test.expected) {
+				t.Errorf("unexpected namespaces: %v", cmp.Diff(test.expected, namespaces))
+			}
+			if len(invalidPatterns) > 0 || len(test.invalidPatterns) > 0 {
+				if !cmp.Equal(invalidPatterns, test.invalidPatterns) {
+					t.Errorf("unexpected invalid patterns: %v", cmp.Diff(test.invalidPatterns, invalidPatterns))
+				}
+			}
+		})
+	}
+}
+
+func TestGetReplicateNamespacesDefaultExclusions(t *testing.T) {
+	allNamespaces := newTestNamespaces("default", "kube-system", "kube-public", "ns-1")
+	defer func(excludeNamespaces string) { configExcludeNamespaces = excludeNamespaces }(configExcludeNamespaces)
+	configExcludeNamespaces = "kube-.*"
+
+	object := &metav1.ObjectMeta{Namespace: "default", Name: "app", Annotations: map[string]string{REPLICATE_ALL_NAMESPACES: "true", EXCLUDE_REGEX: "default"}}
+	namespaces, _, err := getReplicateNamespaces(allNamespaces, object)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cmp.Equal(namespaces, []string{"ns-1"}) {
+		t.Errorf("unexpected namespaces: %v", namespaces)
+	}
+}
+
+func TestSubstringMatchChanges(t *testing.T) {
+	allNamespaces := newTestNamespaces("ns-1", "ns-10", "app-ns-2")
+	tests := []struct {
+		name            string
+		annotations     map[string]string
+		expectedRemoved []string
+	}{
+		{
+			name:            "substring matches are no longer replicated to",
+			annotations:     map[string]string{REPLICATE_REGEX: "ns-.*"},
+			expectedRemoved: []string{"app-ns-2"},
+		},
+		{
+			name:            "excluded namespaces are not reported",
+			annotations:     map[string]string{REPLICATE_REGEX: "ns-.*", EXCLUDE_REGEX: "app-ns-2"},
+			expectedRemoved: []string{},
+		},
+		{
+			name:            "unchanged",
+			annotations:     map[string]string{REPLICATE_REGEX: "ns-1.*"},
+			expectedRemoved: []string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			object := &metav1.ObjectMeta{Namespace: "default", Name: "app", Annotations: test.annotations}
+			removed, err := substringMatchChanges(allNamespaces, object)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !cmp.Equal(removed, test.expectedRemoved) {
+				t.Errorf("unexpected removed namespaces: %v", cmp.Diff(test.expectedRemoved, removed))
+			}
+		})
+	}
+}
+
+func TestParseGroupVersionResources(t *testing.T) {
+	tests := []struct {
+		name        string
+		resources   string
+		expected    []schema.GroupVersionResource
+		expectError bool
+	}{
+		{
+			name:      "empty",
+			resources: "",
+			expected:  []schema.GroupVersionResource{},
+		},
+		{
+			name:      "core and named groups",
+			resources: "networking.k8s.io/v1/networkpolicies, v1/limitranges",
+			expected: []schema.GroupVersionResource{
+				{Group: "networking.k8s.io", Version: "v1", Resource: "networkpolicies"},
+				{Version: "v1", Resource: "limitranges"},
+			},
+		},
+		{
+			name:        "missing version",
+			resources:   "networkpolicies",
+			expectError: true,
+		},
+		{
+			name:        "secrets are always replicated",
+			resources:   "v1/secrets",
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resources, err := parseGroupVersionResources(test.resources)
+			if (err != nil) != test.expectError {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !test.expectError && !cmp.Equal(resources, test.expected) {
+				t.Errorf("unexpected resources: %v", cmp.Diff(test.expected, resources))
+			}
+		})
+	}
+}
+
+func TestGetTargetName(t *testing.T) {
+	tests := []struct {
+		name        string
+		targetName  *string
+		expected    string
+		expectError bool
+	}{
+		{
+			name:     "source name without annotation",
+			expected: "registry-creds-v3",
+		},
+		{
+			name:       "static name",
+			targetName: stringPointer("regcred"),
+			expected:   "regcred",
+		},
+		{
+			name:       "templated name",
+			targetName: stringPointer("{{ .Name }}-{{ .Namespace }}"),
+			expected:   "registry-creds-v3-ns-1",
+		},
+		{
+			name:        "invalid template",
+			targetName:  stringPointer("{{ .Namespace"),
+			expectError: true,
+		},
+		{
+			name:        "unknown template field",
+			targetName:  stringPointer("{{ .Cluster }}"),
+			expectError: true,
+		},
+		{
+			name:        "invalid object name",
+			targetName:  stringPointer("Regcred_{{ .Namespace }}"),
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			obj := &metav1.ObjectMeta{Namespace: "default", Name: "registry-creds-v3", Annotations: map[string]string{}}
+			if test.targetName != nil {
+				obj.Annotations[TARGET_NAME] = *test.targetName
+			}
+			name, err := getTargetName(obj, "ns-1")
+			if (err != nil) != test.expectError {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if name != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, name)
+			}
+		})
+	}
+}
+
+func stringPointer(s string) *string {
+	return &s
+}